@@ -0,0 +1,72 @@
+package etl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+// artifactPath returns the path in object storage of an artifact.
+// It has the form <month>/<RemotePrefix><day>_<name>_<checksum>.<extension>.
+func artifactPath(ec *config.Config, day metadata.Day, name, checksum, extension string) string {
+	return fmt.Sprintf("%s/%s%s_%s_%s.%s", day.MonthString(), ec.RemotePrefix, day, name, checksum, extension)
+}
+
+// artifactPathInfo is the information encoded in the path of an artifact.
+type artifactPathInfo struct {
+	Day       metadata.Day
+	Name      string
+	Checksum  string
+	Extension string
+}
+
+// parseArtifactPath parses a path generated by artifactPath.
+// It returns false if the path is not the path of an artifact.
+func parseArtifactPath(ec *config.Config, p string) (artifactPathInfo, bool) {
+	dir, base := path.Split(p)
+	base, ok := strings.CutPrefix(base, ec.RemotePrefix)
+	if !ok {
+		return artifactPathInfo{}, false
+	}
+	rawDay, rest, ok := strings.Cut(base, "_")
+	if !ok {
+		return artifactPathInfo{}, false
+	}
+	day, err := metadata.ParseDay(rawDay)
+	if err != nil || dir != day.MonthString()+"/" {
+		return artifactPathInfo{}, false
+	}
+	rest, extension, ok := strings.Cut(rest, ".")
+	if !ok || extension == "" {
+		return artifactPathInfo{}, false
+	}
+	i := strings.LastIndex(rest, "_")
+	if i <= 0 {
+		return artifactPathInfo{}, false
+	}
+	name, checksum := rest[:i], rest[i+1:]
+	if !isHex(checksum) {
+		return artifactPathInfo{}, false
+	}
+	return artifactPathInfo{
+		Day:       day,
+		Name:      name,
+		Checksum:  checksum,
+		Extension: extension,
+	}, true
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
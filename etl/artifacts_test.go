@@ -0,0 +1,57 @@
+package etl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestParseArtifactPath(t *testing.T) {
+	ec := &config.Config{RemotePrefix: "subwaydatanyc_"}
+	day := metadata.NewDay(2022, time.January, 7)
+	testCases := []struct {
+		path    string
+		wantOk  bool
+		wantOut artifactPathInfo
+	}{
+		{
+			path:   artifactPath(ec, day, "csv", "cbe30b6f6c38", "tar.xz"),
+			wantOk: true,
+			wantOut: artifactPathInfo{
+				Day:       day,
+				Name:      "csv",
+				Checksum:  "cbe30b6f6c38",
+				Extension: "tar.xz",
+			},
+		},
+		{
+			path:   "2022-01/subwaydatanyc_2022-01-07_sqlite_84469a0ff9e5.db.xz",
+			wantOk: true,
+			wantOut: artifactPathInfo{
+				Day:       day,
+				Name:      "sqlite",
+				Checksum:  "84469a0ff9e5",
+				Extension: "db.xz",
+			},
+		},
+		{path: "metadata.json"},
+		{path: "2022-02/subwaydatanyc_2022-01-07_csv_cbe30b6f6c38.tar.xz"},
+		{path: "2022-01/other_2022-01-07_csv_cbe30b6f6c38.tar.xz"},
+		{path: "2022-01/subwaydatanyc_2022-01-07_csv_README.tar.xz"},
+		{path: "2022-01/subwaydatanyc_2022-01-07_csv_cbe30b6f6c38"},
+		{path: "2022-01/subwaydatanyc_2022-01-07_cbe30b6f6c38.tar.xz"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			out, ok := parseArtifactPath(ec, tc.path)
+			if ok != tc.wantOk {
+				t.Fatalf("parseArtifactPath(%q) ok actual %t != expected %t", tc.path, ok, tc.wantOk)
+			}
+			if out != tc.wantOut {
+				t.Errorf("parseArtifactPath(%q) actual %+v != expected %+v", tc.path, out, tc.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package etl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"reflect"
+	"sort"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+// sha256SumsPath returns the path in object storage of the SHA256SUMS file for a month.
+func sha256SumsPath(month string) string {
+	return fmt.Sprintf("%s/SHA256SUMS", month)
+}
+
+// writeSha256Sums regenerates the SHA256SUMS files for the provided months from the current metadata.
+//
+// The files list the artifacts by their base name, so that `sha256sum -c SHA256SUMS` can be run in a
+// directory containing the downloaded artifacts of the month.
+func writeSha256Sums(ctx context.Context, months map[string]bool, sc *storage.Client) error {
+	if len(months) == 0 {
+		return nil
+	}
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to obtain metadata: %w", err)
+	}
+	sortedMonths := make([]string, 0, len(months))
+	for month := range months {
+		sortedMonths = append(sortedMonths, month)
+	}
+	sort.Strings(sortedMonths)
+	for _, month := range sortedMonths {
+		b := m.Sha256Sums(month, func(_ metadata.Day, _ string, a metadata.Artifact) string {
+			return path.Base(a.Path)
+		})
+		if err := sc.Write(ctx, bytes.NewReader(b), sha256SumsPath(month)); err != nil {
+			return fmt.Errorf("failed to write SHA256SUMS for %s: %w", month, err)
+		}
+		log.Printf("Wrote %s", sha256SumsPath(month))
+	}
+	return nil
+}
+
+// changedMonths returns the months of the days whose artifacts differ between two versions of the metadata.
+// The SHA256SUMS files of these months need to be regenerated when the metadata changes from one to the other.
+func changedMonths(old, new *metadata.Metadata) map[string]bool {
+	artifacts := map[metadata.Day][2]map[string]metadata.Artifact{}
+	for i, m := range []*metadata.Metadata{old, new} {
+		for _, p := range m.ProcessedDays {
+			a := artifacts[p.Day]
+			a[i] = p.Artifacts
+			artifacts[p.Day] = a
+		}
+	}
+	months := map[string]bool{}
+	for day, a := range artifacts {
+		if !reflect.DeepEqual(a[0], a[1]) {
+			months[day.MonthString()] = true
+		}
+	}
+	return months
+}
+
+// computeSha256 downloads an object and returns the hex encoded SHA-256 checksum of its content.
+func computeSha256(ctx context.Context, sc *storage.Client, remotePath string) (string, error) {
+	r, err := sc.Read(ctx, remotePath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,48 @@
+package etl
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestWriteSha256Sums(t *testing.T) {
+	ctx := context.Background()
+	_, sc := newTestClient(t)
+	if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		m.ProcessedDays = []metadata.ProcessedDay{{
+			Day: metadata.NewDay(2022, time.January, 7),
+			Artifacts: map[string]metadata.Artifact{
+				metadata.ArtifactCsv: {
+					Path:     "2022-01/subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz",
+					Checksum: "aaaaaaaaaaaa",
+					Sha256:   "aaaaaaaaaaaa1111",
+				},
+			},
+		}}
+		return true
+	}); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+
+	if err := writeSha256Sums(ctx, map[string]bool{"2022-01": true}, sc); err != nil {
+		t.Fatalf("writeSha256Sums failed: %s", err)
+	}
+
+	r, err := sc.Read(ctx, "2022-01/SHA256SUMS")
+	if err != nil {
+		t.Fatalf("failed to read SHA256SUMS: %s", err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read SHA256SUMS: %s", err)
+	}
+	want := "aaaaaaaaaaaa1111  subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz\n"
+	if string(b) != want {
+		t.Errorf("SHA256SUMS actual %q != expected %q", b, want)
+	}
+}
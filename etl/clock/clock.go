@@ -0,0 +1,213 @@
+// Package clock contains an abstraction of the system clock, so that code that depends on the time can be tested.
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	Now() time.Time
+
+	// NewTimer creates a timer that sends the time on its channel once the duration has elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event timer, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing. It returns false if the timer has already fired or been stopped.
+	Stop() bool
+}
+
+// Real returns the system clock.
+func Real() Clock {
+	return realClock{}
+}
+
+// OrReal returns the clock, or the system clock if it is nil.
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real()
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// Until returns the duration until t according to the clock.
+func Until(c Clock, t time.Time) time.Duration {
+	return t.Sub(c.Now())
+}
+
+// WithDeadline returns a copy of the context that is cancelled when the clock reaches the deadline.
+// Unlike context.WithDeadline, the deadline is measured using the clock.
+func WithDeadline(ctx context.Context, c Clock, deadline time.Time) (context.Context, context.CancelFunc) {
+	ctx, cancelFunc := context.WithCancel(ctx)
+	timer := c.NewTimer(Until(c, deadline))
+	go func() {
+		select {
+		case <-timer.C():
+			cancelFunc()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return ctx, cancelFunc
+}
+
+// WithGracePeriod returns a copy of the context that is not cancelled when the parent is, but instead a grace
+// period later according to the clock. It is used for work that should finish once started.
+func WithGracePeriod(parent context.Context, c Clock, gracePeriod time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancelFunc := context.WithCancel(context.WithoutCancel(parent))
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		timer := c.NewTimer(gracePeriod)
+		select {
+		case <-timer.C():
+			cancelFunc()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return ctx, cancelFunc
+}
+
+// Fake is a clock whose time only changes when it is advanced.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{}
+}
+
+// NewFake returns a fake clock set to the provided time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{f: f, deadline: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- f.now
+	} else {
+		f.timers = append(f.timers, t)
+	}
+	f.notify()
+	return t
+}
+
+// Advance moves the clock forward by the duration, firing any timers that expire along the way in order.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to the provided time, firing any timers that expire by then in order.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].deadline.Before(f.timers[j].deadline)
+	})
+	var remaining []*fakeTimer
+	for _, t := range f.timers {
+		if t.deadline.After(now) {
+			remaining = append(remaining, t)
+			continue
+		}
+		t.c <- t.deadline
+	}
+	f.timers = remaining
+	f.now = now
+	f.notify()
+}
+
+// NumTimers returns the number of timers that are waiting to fire.
+func (f *Fake) NumTimers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// WaitForTimers blocks until at least n timers are waiting to fire, or the context is done.
+// It is used in tests to wait for code running in another goroutine to start waiting on the clock.
+func (f *Fake) WaitForTimers(ctx context.Context, n int) error {
+	for {
+		f.mu.Lock()
+		numTimers, changed := len(f.timers), f.changed
+		f.mu.Unlock()
+		if numTimers >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notify wakes up goroutines waiting for the timers to change. The caller must hold the lock.
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+type fakeTimer struct {
+	f        *Fake
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	for i, other := range t.f.timers {
+		if other == t {
+			t.f.timers = append(t.f.timers[:i], t.f.timers[i+1:]...)
+			t.f.notify()
+			return true
+		}
+	}
+	return false
+}
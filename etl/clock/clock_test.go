@@ -0,0 +1,95 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2022, time.January, 7, 10, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	t1 := c.NewTimer(time.Minute)
+	t2 := c.NewTimer(time.Hour)
+	t3 := c.NewTimer(2 * time.Minute)
+	if !t3.Stop() {
+		t.Errorf("Stop() actual false != expected true")
+	}
+	if c.NumTimers() != 2 {
+		t.Errorf("NumTimers() actual %d != expected 2", c.NumTimers())
+	}
+
+	c.Advance(30 * time.Minute)
+	select {
+	case got := <-t1.C():
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("timer fired at %s != expected %s", got, want)
+		}
+	default:
+		t.Errorf("timer did not fire")
+	}
+	select {
+	case <-t2.C():
+		t.Errorf("timer fired early")
+	case <-t3.C():
+		t.Errorf("stopped timer fired")
+	default:
+	}
+	if got, want := c.Now(), start.Add(30*time.Minute); !got.Equal(want) {
+		t.Errorf("Now() actual %s != expected %s", got, want)
+	}
+	if t1.Stop() {
+		t.Errorf("Stop() of fired timer actual true != expected false")
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	start := time.Date(2022, time.January, 7, 10, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	ctx, cancelFunc := WithDeadline(context.Background(), c, start.Add(time.Hour))
+	defer cancelFunc()
+
+	waitCtx, waitCancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer waitCancelFunc()
+	if err := c.WaitForTimers(waitCtx, 1); err != nil {
+		t.Fatalf("WaitForTimers failed: %s", err)
+	}
+	c.Advance(59 * time.Minute)
+	if ctx.Err() != nil {
+		t.Errorf("context done before the deadline")
+	}
+	c.Advance(time.Minute)
+	select {
+	case <-ctx.Done():
+	case <-waitCtx.Done():
+		t.Errorf("context not done after the deadline")
+	}
+}
+
+func TestWithGracePeriod(t *testing.T) {
+	start := time.Date(2022, time.January, 7, 10, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	parent, parentCancelFunc := context.WithCancel(context.Background())
+	ctx, cancelFunc := WithGracePeriod(parent, c, time.Hour)
+	defer cancelFunc()
+
+	parentCancelFunc()
+	waitCtx, waitCancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer waitCancelFunc()
+	if err := c.WaitForTimers(waitCtx, 1); err != nil {
+		t.Fatalf("WaitForTimers failed: %s", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("context done when the parent was cancelled")
+	}
+	c.Advance(59 * time.Minute)
+	if ctx.Err() != nil {
+		t.Errorf("context done before the end of the grace period")
+	}
+	c.Advance(time.Minute)
+	select {
+	case <-ctx.Done():
+	case <-waitCtx.Done():
+		t.Errorf("context not done after the grace period")
+	}
+}
@@ -16,6 +16,16 @@ type Config struct {
 	// Timezone to use.
 	Timezone Timezone
 
+	// Object storage backend to use: "s3" (the default), "local" or "memory".
+	//
+	// The local backend stores objects in the directory given by LocalStoragePath and the
+	// memory backend keeps objects in memory for the lifetime of the process.
+	// Neither requires the bucket fields below to be set.
+	StorageBackend string
+
+	// Root directory of the local object storage backend.
+	LocalStoragePath string
+
 	// URL of the remote object storage service hosting the bucket.
 	BucketUrl string
 
@@ -36,6 +46,42 @@ type Config struct {
 
 	// Path within object storage to the JSON metadata file.
 	MetadataPath string
+
+	// Time for which snapshots of previous versions of the metadata are kept. Older snapshots are deleted when
+	// the metadata is written, except for the newest one. Defaults to 90 days if zero.
+	MetadataHistoryRetention Duration
+
+	// Periods longer than this without GTFS-RT data for a feed are recorded as coverage gaps.
+	// Defaults to 5 minutes if zero.
+	CoverageGapThreshold Duration
+
+	// Retry policies for the stages of the pipeline that use remote services, keyed by stage name.
+	// The stages are "download", which retrieves data from Hoard, and "upload", which writes artifacts
+	// to object storage. Stages without a policy here use a built-in default policy.
+	RetryPolicies map[string]RetryPolicy
+
+	// Time the backlog waits before retrying a day that failed. The wait doubles after each consecutive failure.
+	// Defaults to 1 hour if zero.
+	FailedDayBackoff Duration
+
+	// Number of consecutive failures after which the backlog stops retrying a day until its failure record
+	// is cleared. Defaults to 5 if zero.
+	MaxFailedAttempts int
+}
+
+// RetryPolicy describes how a failed stage of the pipeline is retried.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Values less than 1 are treated as 1.
+	MaxAttempts int
+
+	// Time to wait before the first retry. The wait doubles after each subsequent failed attempt.
+	InitialBackoff Duration
+
+	// Maximum time to wait between attempts. If zero, the wait is not capped.
+	MaxBackoff Duration
+
+	// Errors whose message contains any of these strings are not retried.
+	NonRetryableErrors []string
 }
 
 type Feed struct {
@@ -76,6 +122,45 @@ func (t Timezone) MarshalJSON() ([]byte, error) {
 	return json.Marshal(t.name)
 }
 
+// Duration is a time.Duration that is serialized to JSON as a string like "5m0s".
+type Duration time.Duration
+
+func (d Duration) AsDuration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// FeedIDsForDay returns the IDs of the feeds that are active on the provided day.
+func FeedIDsForDay(feeds []Feed, day metadata.Day) []string {
+	var feedIDs []string
+	for _, feed := range feeds {
+		if day.Before(feed.FirstDay) {
+			continue
+		}
+		if feed.LastDay != nil && feed.LastDay.Before(day) {
+			continue
+		}
+		feedIDs = append(feedIDs, feed.Id)
+	}
+	return feedIDs
+}
+
 func CalculatePendingDays(feeds []Feed, processedDays []metadata.ProcessedDay, lastDay metadata.Day, softwareVersion int) []PendingDay {
 	upperBound := lastDay.Next()
 
@@ -210,3 +210,30 @@ func TestCalculatePendingDays(t *testing.T) {
 		})
 	}
 }
+
+func TestFeedIDsForDay(t *testing.T) {
+	jan2 := metadata.NewDay(2022, time.January, 2)
+	jan3 := metadata.NewDay(2022, time.January, 3)
+	jan4 := metadata.NewDay(2022, time.January, 4)
+	feeds := []Feed{
+		{Id: "feedID1", FirstDay: jan2, LastDay: &jan3},
+		{Id: "feedID2", FirstDay: jan3},
+	}
+	testCases := []struct {
+		day     metadata.Day
+		wantOut []string
+	}{
+		{day: metadata.NewDay(2022, time.January, 1), wantOut: nil},
+		{day: jan2, wantOut: []string{"feedID1"}},
+		{day: jan3, wantOut: []string{"feedID1", "feedID2"}},
+		{day: jan4, wantOut: []string{"feedID2"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.day.String(), func(t *testing.T) {
+			out := FeedIDsForDay(feeds, tc.day)
+			if !reflect.DeepEqual(out, tc.wantOut) {
+				t.Errorf("FeedIDsForDay(%s) actual %v != expected %v", tc.day, out, tc.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package export
+
+import (
+	"archive/tar"
+	"sort"
+	"time"
+
+	"github.com/jamespfennell/gtfs/journal"
+)
+
+// archiveModTime is the modification time of the generated files in archives.
+// A fixed time is used so that exporting the same data twice produces byte-for-byte identical archives.
+// The xz writer always uses the same compression settings, so compressed archives are reproducible too.
+var archiveModTime = time.Unix(0, 0).UTC()
+
+// archiveHeader returns the tar header for a file in an archive.
+//
+// Only the name, size and modification time are set, and the format is fixed, so that the header
+// does not depend on the machine or time at which the archive is built.
+func archiveHeader(name string, size int64, modTime time.Time) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     size,
+		ModTime:  modTime.UTC().Truncate(time.Second),
+		Format:   tar.FormatPAX,
+	}
+}
+
+// sortedTrips returns a shallow copy of the journal with the trips sorted by trip UID.
+// The order of the trips in a journal depends on the order in which the GTFS-RT data was read.
+func sortedTrips(j *journal.Journal) *journal.Journal {
+	sorted := *j
+	sorted.Trips = append([]journal.Trip(nil), j.Trips...)
+	sort.SliceStable(sorted.Trips, func(i, k int) bool {
+		return sorted.Trips[i].TripUID < sorted.Trips[k].TripUID
+	})
+	return &sorted
+}
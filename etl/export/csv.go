@@ -0,0 +1,108 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jamespfennell/gtfs"
+	"github.com/jamespfennell/gtfs/journal"
+)
+
+// The csv files have the same format as the ones produced by journal.ExportToCsv. They are written row by
+// row, rather than using ExportToCsv, so that the whole file is never held in memory.
+
+const tripsCsvHeader = "trip_uid,trip_id,route_id,direction_id,start_time,vehicle_id,last_observed,marked_past,num_updates,num_schedule_changes,num_schedule_rewrites\n"
+
+const stopTimesCsvHeader = "trip_uid,stop_id,track,arrival_time,departure_time,last_observed,marked_past\n"
+
+// csvFile is a csv file that is generated from the trips of a journal.
+type csvFile struct {
+	name  string
+	write func(w io.Writer, trips []journal.Trip) error
+}
+
+var csvFiles = []csvFile{
+	{"trips.csv", writeTripsCsv},
+	{"stop_times.csv", writeStopTimesCsv},
+}
+
+func writeTripsCsv(w io.Writer, trips []journal.Trip) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(tripsCsvHeader)
+	for i := range trips {
+		trip := &trips[i]
+		fmt.Fprintf(bw, "%s,%s,%s,%s,%d,%s,%d,%s,%d,%d,%d\n",
+			trip.TripUID,
+			trip.TripID,
+			trip.RouteID,
+			formatDirectionID(trip.DirectionID),
+			trip.StartTime.Unix(),
+			trip.VehicleID,
+			trip.LastObserved.Unix(),
+			formatNullableUnix(trip.MarkedPast),
+			trip.NumUpdates,
+			trip.NumScheduleChanges,
+			trip.NumScheduleRewrites,
+		)
+	}
+	return bw.Flush()
+}
+
+func writeStopTimesCsv(w io.Writer, trips []journal.Trip) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(stopTimesCsvHeader)
+	for i := range trips {
+		trip := &trips[i]
+		for k := range trip.StopTimes {
+			stopTime := &trip.StopTimes[k]
+			fmt.Fprintf(bw, "%s,%s,%s,%s,%s,%d,%s\n",
+				trip.TripUID,
+				stopTime.StopID,
+				formatNullableString(stopTime.Track),
+				formatNullableUnix(stopTime.ArrivalTime),
+				formatNullableUnix(stopTime.DepartureTime),
+				stopTime.LastObserved.Unix(),
+				formatNullableUnix(stopTime.MarkedPast),
+			)
+		}
+	}
+	return bw.Flush()
+}
+
+func formatDirectionID(d gtfs.DirectionID) string {
+	switch d {
+	case gtfs.DirectionID_False:
+		return "0"
+	case gtfs.DirectionID_True:
+		return "1"
+	default:
+		return ""
+	}
+}
+
+func formatNullableUnix(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func formatNullableString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// countingWriter discards everything written to it and counts the number of bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -2,48 +2,50 @@ package export
 
 import (
 	"archive/tar"
-	"bytes"
-	_ "embed"
+	"io"
 
 	"github.com/jamespfennell/gtfs/journal"
 	"github.com/jamespfennell/xz"
 )
 
-// Export exports the provided journal as a tar.xz archive of csv files.
-func Export(j *journal.Journal, filePrefix string) ([]byte, error) {
-	csvExport, err := j.ExportToCsv()
-	if err != nil {
-		return nil, err
-	}
-	var out bytes.Buffer
-	xw := xz.NewWriter(&out)
+// File is an additional file to include in the export.
+type File struct {
+	Name string
+	Body []byte
+}
+
+// Export exports the provided journal as a tar.xz archive of csv files written to w.
+// The extra files are added to the archive after the csv files.
+// The archive only depends on the trips in the journal, not on their order.
+func Export(j *journal.Journal, filePrefix string, w io.Writer, extraFiles ...File) error {
+	trips := sortedTrips(j).Trips
+	xw := xz.NewWriter(w)
 	tw := tar.NewWriter(xw)
-	var files = []struct {
-		Name string
-		Body []byte
-	}{
-		{"trips.csv", csvExport.TripsCsv},
-		{"stop_times.csv", csvExport.StopTimesCsv},
-		// TODO: add a readme
-	}
-	for _, file := range files {
-		hdr := &tar.Header{
-			Name: filePrefix + file.Name,
-			Mode: 0600,
-			Size: int64(len(file.Body)),
+	// The size of a file has to be written to the tar header before the file. To avoid holding the csv
+	// files in memory each one is generated twice: once to measure its size and once to write it.
+	for _, file := range csvFiles {
+		var size countingWriter
+		if err := file.write(&size, trips); err != nil {
+			return err
 		}
-		if err := tw.WriteHeader(hdr); err != nil {
-			return nil, err
+		if err := tw.WriteHeader(archiveHeader(filePrefix+file.name, size.n, archiveModTime)); err != nil {
+			return err
 		}
-		if _, err := tw.Write([]byte(file.Body)); err != nil {
-			return nil, err
+		if err := file.write(tw, trips); err != nil {
+			return err
 		}
 	}
-	if err := tw.Close(); err != nil {
-		return nil, err
+	// TODO: add a readme
+	for _, file := range extraFiles {
+		if err := tw.WriteHeader(archiveHeader(filePrefix+file.Name, int64(len(file.Body)), archiveModTime)); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.Body); err != nil {
+			return err
+		}
 	}
-	if err := xw.Close(); err != nil {
-		return nil, err
+	if err := tw.Close(); err != nil {
+		return err
 	}
-	return out.Bytes(), nil
+	return xw.Close()
 }
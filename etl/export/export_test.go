@@ -3,14 +3,22 @@ package export
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/jamespfennell/gtfs"
 	"github.com/jamespfennell/gtfs/journal"
 	"github.com/jamespfennell/xz"
+	"github.com/parquet-go/parquet-go"
 )
 
 var trip journal.Trip = journal.Trip{
@@ -64,12 +72,12 @@ func TestAsCsv(t *testing.T) {
 	prefix := "somePrefix_"
 	j := journal.Journal{Trips: []journal.Trip{trip}}
 
-	result, err := Export(&j, prefix)
-	if err != nil {
+	var result bytes.Buffer
+	if err := Export(&j, prefix, &result, File{Name: "extra.json", Body: []byte("{}")}); err != nil {
 		t.Fatalf("AsCsv function failed: %s", err)
 	}
 
-	actualFiles := unTar(result)
+	actualFiles := unTar(result.Bytes())
 
 	tripsCsv, ok := actualFiles[prefix+"trips.csv"]
 	if !ok {
@@ -84,13 +92,298 @@ func TestAsCsv(t *testing.T) {
 	} else if stopTimesCsv != expectedStopTimesCsv {
 		t.Errorf("Stop times file actual:\n%s\n!= expected:\n%s\n", stopTimesCsv, expectedStopTimesCsv)
 	}
+
+	if extra, ok := actualFiles[prefix+"extra.json"]; !ok {
+		t.Errorf("Did not find extra file in tar file")
+	} else if extra != "{}" {
+		t.Errorf("Extra file actual %q != expected %q", extra, "{}")
+	}
+}
+
+func TestCsvMatchesJournalExport(t *testing.T) {
+	otherTrip := trip
+	otherTrip.TripUID = "OtherTripUID"
+	otherTrip.MarkedPast = nil
+	j := sortedTrips(&journal.Journal{Trips: []journal.Trip{trip, otherTrip}})
+
+	expected, err := j.ExportToCsv()
+	if err != nil {
+		t.Fatalf("ExportToCsv failed: %s", err)
+	}
+	for _, c := range []struct {
+		write    func(io.Writer, []journal.Trip) error
+		expected []byte
+	}{
+		{writeTripsCsv, expected.TripsCsv},
+		{writeStopTimesCsv, expected.StopTimesCsv},
+	} {
+		var actual bytes.Buffer
+		if err := c.write(&actual, j.Trips); err != nil {
+			t.Fatalf("failed to write csv: %s", err)
+		}
+		if actual.String() != string(c.expected) {
+			t.Errorf("csv file actual:\n%s\n!= expected:\n%s\n", actual.String(), c.expected)
+		}
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	j := journal.Journal{Trips: []journal.Trip{trip}}
+	utc := func(t time.Time) time.Time {
+		return t.UTC()
+	}
+	wantTrips := []parquetTrip{{
+		TripUID:             "TripUID",
+		TripID:              "TripID",
+		RouteID:             "RouteID",
+		DirectionID:         ptr(true),
+		StartTime:           utc(time.Unix(100, 0)),
+		VehicleID:           "VehicleID",
+		LastObserved:        utc(time.Unix(400, 0)),
+		MarkedPast:          600000,
+		NumUpdates:          100,
+		NumScheduleChanges:  2,
+		NumScheduleRewrites: 1,
+	}}
+	wantStopTimes := []parquetStopTime{
+		{
+			TripUID:       "TripUID",
+			StopID:        "StopID1",
+			Track:         ptr("Track1"),
+			DepartureTime: 200000,
+			LastObserved:  utc(time.Unix(200, 0)),
+			MarkedPast:    300000,
+		},
+		{
+			TripUID:       "TripUID",
+			StopID:        "StopID2",
+			ArrivalTime:   300000,
+			DepartureTime: 400000,
+			LastObserved:  utc(time.Unix(400, 0)),
+		},
+		{
+			TripUID:      "TripUID",
+			StopID:       "StopID3",
+			Track:        ptr("Track3"),
+			ArrivalTime:  500000,
+			LastObserved: utc(time.Unix(400, 0)),
+		},
+	}
+
+	var trips bytes.Buffer
+	if err := ExportParquet(&j, ParquetTrips, &trips); err != nil {
+		t.Fatalf("ExportParquet function failed: %s", err)
+	}
+	gotTrips, err := parquet.Read[parquetTrip](bytes.NewReader(trips.Bytes()), int64(trips.Len()))
+	if err != nil {
+		t.Fatalf("failed to read trips Parquet file: %s", err)
+	}
+	if !reflect.DeepEqual(gotTrips, wantTrips) {
+		t.Errorf("trips actual %+v != expected %+v", gotTrips, wantTrips)
+	}
+
+	var stopTimes bytes.Buffer
+	if err := ExportParquet(&j, ParquetStopTimes, &stopTimes); err != nil {
+		t.Fatalf("ExportParquet function failed: %s", err)
+	}
+	gotStopTimes, err := parquet.Read[parquetStopTime](bytes.NewReader(stopTimes.Bytes()), int64(stopTimes.Len()))
+	if err != nil {
+		t.Fatalf("failed to read stop times Parquet file: %s", err)
+	}
+	if !reflect.DeepEqual(gotStopTimes, wantStopTimes) {
+		t.Errorf("stop times actual %+v != expected %+v", gotStopTimes, wantStopTimes)
+	}
+	// Missing values are null, rather than zero.
+	f, err := parquet.OpenFile(bytes.NewReader(stopTimes.Bytes()), int64(stopTimes.Len()))
+	if err != nil {
+		t.Fatalf("failed to open stop times Parquet file: %s", err)
+	}
+	rows := make([]parquet.Row, len(wantStopTimes))
+	if n, err := parquet.NewReader(f).ReadRows(rows); n != len(rows) {
+		t.Fatalf("failed to read stop times rows: read %d rows: %s", n, err)
+	}
+	for _, c := range []struct {
+		row    int
+		column string
+	}{
+		{0, "arrival_time"},
+		{1, "track"},
+		{1, "marked_past"},
+		{2, "departure_time"},
+	} {
+		leaf, _ := f.Schema().Lookup(c.column)
+		if v := rows[c.row][leaf.ColumnIndex]; !v.IsNull() {
+			t.Errorf("stop time %d column %s actual %v != expected null", c.row, c.column, v)
+		}
+	}
+
+	if err := ExportParquet(&j, "unknown", io.Discard); err == nil {
+		t.Errorf("ExportParquet with unknown table succeeded, want error")
+	}
+}
+
+func TestExportParquet_RowGroups(t *testing.T) {
+	var j journal.Journal
+	for i := 0; i < parquetRowGroupSize+1; i++ {
+		trip := trip
+		trip.TripUID = fmt.Sprintf("TripUID%d", i)
+		j.Trips = append(j.Trips, trip)
+	}
+	var result bytes.Buffer
+	if err := ExportParquet(&j, ParquetTrips, &result); err != nil {
+		t.Fatalf("ExportParquet function failed: %s", err)
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(result.Bytes()), int64(result.Len()))
+	if err != nil {
+		t.Fatalf("failed to open Parquet file: %s", err)
+	}
+	if f.NumRows() != int64(len(j.Trips)) || len(f.RowGroups()) != 2 {
+		t.Errorf("file has %d rows in %d row groups, expected %d rows in 2 row groups", f.NumRows(), len(f.RowGroups()), len(j.Trips))
+	}
+}
+
+func TestExportSqlite(t *testing.T) {
+	j := journal.Journal{Trips: []journal.Trip{trip}}
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	if err := ExportSqlite(context.Background(), &j, path); err != nil {
+		t.Fatalf("ExportSqlite function failed: %s", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer db.Close()
+	var routeID string
+	var directionID, markedPast int64
+	if err := db.QueryRow(`SELECT route_id, direction_id, marked_past FROM trips WHERE trip_uid = ?`, trip.TripUID).
+		Scan(&routeID, &directionID, &markedPast); err != nil {
+		t.Fatalf("failed to query trips: %s", err)
+	}
+	if routeID != trip.RouteID || directionID != 1 || markedPast != 600 {
+		t.Errorf("trip actual (%s, %d, %d) != expected (%s, 1, 600)", routeID, directionID, markedPast, trip.RouteID)
+	}
+
+	rows, err := db.Query(`
+		SELECT stop_times.stop_id, stop_times.arrival_time
+		FROM stop_times JOIN trips ON stop_times.trip_uid = trips.trip_uid
+		WHERE trips.route_id = ?
+		ORDER BY stop_times.stop_id`, trip.RouteID)
+	if err != nil {
+		t.Fatalf("failed to query stop times: %s", err)
+	}
+	defer rows.Close()
+	var actual []string
+	for rows.Next() {
+		var stopID string
+		var arrivalTime sql.NullInt64
+		if err := rows.Scan(&stopID, &arrivalTime); err != nil {
+			t.Fatalf("failed to scan stop time: %s", err)
+		}
+		actual = append(actual, fmt.Sprintf("%s:%v", stopID, arrivalTime.Int64))
+	}
+	expected := []string{"StopID1:0", "StopID2:300", "StopID3:500"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("stop times actual %v != expected %v", actual, expected)
+	}
+
+	if _, err := db.Exec(`INSERT INTO stop_times (trip_uid, stop_id, last_observed) VALUES ('unknown', 'StopID', 0)`); err == nil {
+		t.Errorf("inserted stop time with unknown trip UID, expected foreign key error")
+	}
+}
+
+func TestExporters(t *testing.T) {
+	j := journal.Journal{Trips: []journal.Trip{trip}}
+	for _, e := range []Exporter{
+		&CsvExporter{FilePrefix: "somePrefix_"},
+		&ParquetExporter{Table: ParquetTrips},
+		&ParquetExporter{Table: ParquetStopTimes},
+		&SqliteExporter{},
+		&GtfsrtExporter{},
+	} {
+		t.Run(e.Name(), func(t *testing.T) {
+			r, err := e.Export(context.Background(), &j, t.TempDir())
+			if err != nil {
+				t.Fatalf("Export failed: %s", err)
+			}
+			if c, ok := r.(io.Closer); ok {
+				defer c.Close()
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to read export: %s", err)
+			}
+			if len(b) == 0 {
+				t.Errorf("export is empty")
+			}
+			if members := e.ExpectedMembers(); len(members) > 0 {
+				files := unTar(b)
+				for _, member := range members {
+					if _, ok := files[member]; !ok {
+						t.Errorf("archive does not contain expected member %s", member)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExportersAreReproducible(t *testing.T) {
+	otherTrip := trip
+	otherTrip.TripUID = "OtherTripUID"
+	rawDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(rawDir, "feedID"), 0755); err != nil {
+		t.Fatalf("failed to create feed directory: %s", err)
+	}
+	snapshot := filepath.Join(rawDir, "feedID", "snapshot.gtfsrt")
+	if err := os.WriteFile(snapshot, []byte("data"), 0600); err != nil {
+		t.Fatalf("failed to write snapshot: %s", err)
+	}
+	if err := os.Chtimes(snapshot, time.Unix(150, 0), time.Unix(150, 0)); err != nil {
+		t.Fatalf("failed to set snapshot time: %s", err)
+	}
+	for _, e := range []Exporter{
+		&CsvExporter{FilePrefix: "somePrefix_"},
+		&ParquetExporter{Table: ParquetTrips},
+		&ParquetExporter{Table: ParquetStopTimes},
+		&SqliteExporter{},
+		&GtfsrtExporter{FeedIDs: []string{"feedID"}, Start: time.Unix(100, 0), End: time.Unix(200, 0)},
+	} {
+		t.Run(e.Name(), func(t *testing.T) {
+			var checksums []string
+			for _, trips := range [][]journal.Trip{{trip, otherTrip}, {otherTrip, trip}} {
+				r, err := e.Export(context.Background(), &journal.Journal{Trips: trips}, rawDir)
+				if err != nil {
+					t.Fatalf("Export failed: %s", err)
+				}
+				h := sha256.New()
+				_, err = io.Copy(h, r)
+				if c, ok := r.(io.Closer); ok {
+					c.Close()
+				}
+				if err != nil {
+					t.Fatalf("failed to read export: %s", err)
+				}
+				checksums = append(checksums, fmt.Sprintf("%x", h.Sum(nil)))
+				if len(checksums) == 1 {
+					// Ensure the exports straddle a second boundary, the resolution of tar modification times.
+					time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+				}
+			}
+			if checksums[0] != checksums[1] {
+				t.Errorf("checksum of second export %s != checksum of first export %s", checksums[1], checksums[0])
+			}
+		})
+	}
 }
 
 func unTar(b []byte) map[string]string {
+	return readTar(xz.NewReader(bytes.NewBuffer(b)))
+}
+
+func readTar(r io.Reader) map[string]string {
 	result := map[string]string{}
-	buf := bytes.NewBuffer(b)
-	xr := xz.NewReader(buf)
-	tr := tar.NewReader(xr)
+	tr := tar.NewReader(r)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
@@ -0,0 +1,162 @@
+package export
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/jamespfennell/xz"
+)
+
+// Exporter exports one day of data in a specific format.
+type Exporter interface {
+	// Name returns the name of the artifact, for example "csv".
+	// It is used in the remote path of the artifact and as the key of the artifact in the metadata.
+	Name() string
+
+	// Extension returns the file extension of the artifact, without a leading period.
+	Extension() string
+
+	// Export exports the journal and the raw GTFS-RT data in rawDir.
+	// The raw data for each feed is in a subdirectory of rawDir named after the feed.
+	//
+	// The export may be produced while the returned reader is being read.
+	// If the reader is also an io.Closer, the caller must close it when done.
+	Export(ctx context.Context, j *journal.Journal, rawDir string) (io.Reader, error)
+
+	// ExpectedMembers returns the files that the artifact contains if it is an archive, or nil otherwise.
+	// It is used to verify artifacts that have been published.
+	ExpectedMembers() []string
+}
+
+// CsvExporter exports trips and stop times as a tar.xz archive of csv files.
+type CsvExporter struct {
+	FilePrefix string
+	ExtraFiles []File
+}
+
+func (e *CsvExporter) Name() string {
+	return "csv"
+}
+
+func (e *CsvExporter) Extension() string {
+	return "tar.xz"
+}
+
+func (e *CsvExporter) ExpectedMembers() []string {
+	var members []string
+	for _, file := range csvFiles {
+		members = append(members, e.FilePrefix+file.name)
+	}
+	return members
+}
+
+func (e *CsvExporter) Export(ctx context.Context, j *journal.Journal, rawDir string) (io.Reader, error) {
+	return pipe(func(w io.Writer) error {
+		return Export(j, e.FilePrefix, w, e.ExtraFiles...)
+	}), nil
+}
+
+// ParquetExporter exports a table of trips or stop times as a Parquet file.
+// The files are published directly, rather than in an archive, so that tools like DuckDB and Spark can
+// query them remotely.
+type ParquetExporter struct {
+	Table ParquetTable
+}
+
+func (e *ParquetExporter) Name() string {
+	return "parquet_" + string(e.Table)
+}
+
+func (e *ParquetExporter) Extension() string {
+	return "parquet"
+}
+
+func (e *ParquetExporter) ExpectedMembers() []string {
+	return nil
+}
+
+func (e *ParquetExporter) Export(ctx context.Context, j *journal.Journal, rawDir string) (io.Reader, error) {
+	return pipe(func(w io.Writer) error {
+		return ExportParquet(j, e.Table, w)
+	}), nil
+}
+
+// SqliteExporter exports trips and stop times as an xz compressed SQLite database.
+// SQLite files compress very well.
+type SqliteExporter struct{}
+
+func (e *SqliteExporter) Name() string {
+	return "sqlite"
+}
+
+func (e *SqliteExporter) Extension() string {
+	return "db.xz"
+}
+
+func (e *SqliteExporter) ExpectedMembers() []string {
+	return nil
+}
+
+func (e *SqliteExporter) Export(ctx context.Context, j *journal.Journal, rawDir string) (io.Reader, error) {
+	tmpDir, err := os.MkdirTemp("", "subwaydatanyc_sqlite_*")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(tmpDir, "db.sqlite")
+	if err := ExportSqlite(ctx, j, path); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	return pipe(func(w io.Writer) error {
+		defer os.RemoveAll(tmpDir)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		xw := xz.NewWriter(w)
+		if _, err := io.Copy(xw, f); err != nil {
+			return err
+		}
+		return xw.Close()
+	}), nil
+}
+
+// GtfsrtExporter exports the raw GTFS-RT snapshots in the interval [Start, End] as a tar.xz archive.
+type GtfsrtExporter struct {
+	FeedIDs []string
+	Start   time.Time
+	End     time.Time
+}
+
+func (e *GtfsrtExporter) Name() string {
+	return "gtfsrt"
+}
+
+func (e *GtfsrtExporter) Extension() string {
+	return "tar.xz"
+}
+
+func (e *GtfsrtExporter) ExpectedMembers() []string {
+	return []string{gtfsrtReadmeName}
+}
+
+func (e *GtfsrtExporter) Export(ctx context.Context, j *journal.Journal, rawDir string) (io.Reader, error) {
+	return pipe(func(w io.Writer) error {
+		return ExportGtfsrt(e.Start, e.End, rawDir, e.FeedIDs, w)
+	}), nil
+}
+
+// pipe runs the write function in a goroutine and returns a reader of the data it writes.
+// Closing the reader before all of the data has been read causes further writes to fail.
+func pipe(write func(w io.Writer) error) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(write(w))
+	}()
+	return r
+}
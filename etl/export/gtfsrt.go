@@ -0,0 +1,70 @@
+package export
+
+import (
+	"archive/tar"
+	_ "embed"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jamespfennell/xz"
+)
+
+//go:embed gtfsrt_readme.md
+var gtfsrtReadme []byte
+
+// We chose this file name so that it appears first in the archive file.
+// The filename readme.md would appear below the nycsubway_*.gtfsrt data files.
+const gtfsrtReadmeName = "gtfsrt_readme.md"
+
+// ExportGtfsrt writes a tar xz archive of all GTFS-RT snapshots in the interval [start, end] to w.
+// The snapshots for each feed are read from the subdirectory of sourceDir named after the feed.
+// Feeds are added in sorted order and, within each feed, snapshots are added in file name order.
+func ExportGtfsrt(start, end time.Time, sourceDir string, feedIDs []string, w io.Writer) error {
+	xw := xz.NewWriter(w)
+	tw := tar.NewWriter(xw)
+	if err := tw.WriteHeader(archiveHeader(gtfsrtReadmeName, int64(len(gtfsrtReadme)), archiveModTime)); err != nil {
+		return err
+	}
+	if _, err := tw.Write(gtfsrtReadme); err != nil {
+		return err
+	}
+	feedIDs = append([]string(nil), feedIDs...)
+	sort.Strings(feedIDs)
+	for _, feedID := range feedIDs {
+		files, err := os.ReadDir(filepath.Join(sourceDir, feedID))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().Before(start) || end.Before(info.ModTime()) {
+				continue
+			}
+			// The modification time of a snapshot is the time it was downloaded, which is part of the input data.
+			if err := tw.WriteHeader(archiveHeader(file.Name(), info.Size(), info.ModTime())); err != nil {
+				return err
+			}
+			f, err := os.Open(filepath.Join(sourceDir, feedID, file.Name()))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, f); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return xw.Close()
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jamespfennell/gtfs"
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Row of the trips Parquet file. Timestamps are stored with millisecond precision.
+// Optional timestamps are milliseconds since the Unix epoch, and are written as null if they are zero.
+type parquetTrip struct {
+	TripUID             string    `parquet:"trip_uid"`
+	TripID              string    `parquet:"trip_id"`
+	RouteID             string    `parquet:"route_id"`
+	DirectionID         *bool     `parquet:"direction_id,optional"`
+	StartTime           time.Time `parquet:"start_time,timestamp(millisecond)"`
+	VehicleID           string    `parquet:"vehicle_id"`
+	LastObserved        time.Time `parquet:"last_observed,timestamp(millisecond)"`
+	MarkedPast          int64     `parquet:"marked_past,optional,timestamp(millisecond)"`
+	NumUpdates          int64     `parquet:"num_updates"`
+	NumScheduleChanges  int64     `parquet:"num_schedule_changes"`
+	NumScheduleRewrites int64     `parquet:"num_schedule_rewrites"`
+}
+
+// Row of the stop times Parquet file.
+type parquetStopTime struct {
+	TripUID       string    `parquet:"trip_uid"`
+	StopID        string    `parquet:"stop_id"`
+	Track         *string   `parquet:"track,optional"`
+	ArrivalTime   int64     `parquet:"arrival_time,optional,timestamp(millisecond)"`
+	DepartureTime int64     `parquet:"departure_time,optional,timestamp(millisecond)"`
+	LastObserved  time.Time `parquet:"last_observed,timestamp(millisecond)"`
+	MarkedPast    int64     `parquet:"marked_past,optional,timestamp(millisecond)"`
+}
+
+// ParquetTable is a table that can be exported as a Parquet file.
+type ParquetTable string
+
+const (
+	ParquetTrips     ParquetTable = "trips"
+	ParquetStopTimes ParquetTable = "stop_times"
+)
+
+// Number of rows in each row group of the Parquet files.
+// Rows are buffered in memory until a row group is complete, so this bounds the memory used by an export.
+const parquetRowGroupSize = 64 * 1024
+
+// ExportParquet exports a table of the provided journal as a Parquet file written to w.
+//
+// The Parquet files have the same columns as the csv files, but with typed values.
+// Rows are written in row groups, so the file is never held in memory.
+func ExportParquet(j *journal.Journal, table ParquetTable, w io.Writer) error {
+	j = sortedTrips(j)
+	switch table {
+	case ParquetTrips:
+		pw := newParquetWriter[parquetTrip](w)
+		for _, trip := range j.Trips {
+			if _, err := pw.Write([]parquetTrip{{
+				TripUID:             trip.TripUID,
+				TripID:              trip.TripID,
+				RouteID:             trip.RouteID,
+				DirectionID:         directionID(trip.DirectionID),
+				StartTime:           trip.StartTime,
+				VehicleID:           trip.VehicleID,
+				LastObserved:        trip.LastObserved,
+				MarkedPast:          unixMilliOrZero(trip.MarkedPast),
+				NumUpdates:          int64(trip.NumUpdates),
+				NumScheduleChanges:  int64(trip.NumScheduleChanges),
+				NumScheduleRewrites: int64(trip.NumScheduleRewrites),
+			}}); err != nil {
+				return err
+			}
+		}
+		return pw.Close()
+	case ParquetStopTimes:
+		pw := newParquetWriter[parquetStopTime](w)
+		for _, trip := range j.Trips {
+			for _, stopTime := range trip.StopTimes {
+				if _, err := pw.Write([]parquetStopTime{{
+					TripUID:       trip.TripUID,
+					StopID:        stopTime.StopID,
+					Track:         stopTime.Track,
+					ArrivalTime:   unixMilliOrZero(stopTime.ArrivalTime),
+					DepartureTime: unixMilliOrZero(stopTime.DepartureTime),
+					LastObserved:  stopTime.LastObserved,
+					MarkedPast:    unixMilliOrZero(stopTime.MarkedPast),
+				}}); err != nil {
+					return err
+				}
+			}
+		}
+		return pw.Close()
+	default:
+		return fmt.Errorf("unknown Parquet table %q", table)
+	}
+}
+
+func newParquetWriter[T any](w io.Writer) *parquet.GenericWriter[T] {
+	return parquet.NewGenericWriter[T](w,
+		parquet.Compression(&parquet.Gzip),
+		parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+	)
+}
+
+func directionID(d gtfs.DirectionID) *bool {
+	var b bool
+	switch d {
+	case gtfs.DirectionID_True:
+		b = true
+	case gtfs.DirectionID_False:
+		b = false
+	default:
+		return nil
+	}
+	return &b
+}
+
+func unixMilliOrZero(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixMilli()
+}
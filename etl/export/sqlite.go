@@ -0,0 +1,150 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jamespfennell/gtfs"
+	"github.com/jamespfennell/gtfs/journal"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The schema mirrors the csv files. Times are stored as Unix timestamps in seconds.
+var sqliteSchema = []string{
+	`CREATE TABLE trips (
+		trip_uid TEXT PRIMARY KEY,
+		trip_id TEXT NOT NULL,
+		route_id TEXT NOT NULL,
+		direction_id INTEGER,
+		start_time INTEGER NOT NULL,
+		vehicle_id TEXT NOT NULL,
+		last_observed INTEGER NOT NULL,
+		marked_past INTEGER,
+		num_updates INTEGER NOT NULL,
+		num_schedule_changes INTEGER NOT NULL,
+		num_schedule_rewrites INTEGER NOT NULL
+	)`,
+	`CREATE TABLE stop_times (
+		trip_uid TEXT NOT NULL REFERENCES trips(trip_uid),
+		stop_id TEXT NOT NULL,
+		track TEXT,
+		arrival_time INTEGER,
+		departure_time INTEGER,
+		last_observed INTEGER NOT NULL,
+		marked_past INTEGER
+	)`,
+}
+
+// Indexes are created after the data is inserted, which is much faster than maintaining them
+// during the inserts.
+var sqliteIndexes = []string{
+	`CREATE INDEX trips_route_id ON trips(route_id)`,
+	`CREATE INDEX trips_start_time ON trips(start_time)`,
+	`CREATE INDEX stop_times_trip_uid ON stop_times(trip_uid)`,
+	`CREATE INDEX stop_times_stop_id ON stop_times(stop_id)`,
+	`CREATE INDEX stop_times_arrival_time ON stop_times(arrival_time)`,
+	`CREATE INDEX stop_times_departure_time ON stop_times(departure_time)`,
+}
+
+// ExportSqlite exports the provided journal as a SQLite database at the provided path.
+//
+// The database has trips and stop_times tables with the same columns as the csv files.
+// The file at path must not already exist.
+func ExportSqlite(ctx context.Context, j *journal.Journal, path string) error {
+	j = sortedTrips(j)
+	// The database is written once and then discarded locally, so there is no need for the
+	// rollback journal or for syncing to disk.
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=rwc&_foreign_keys=on&_journal_mode=OFF&_sync=OFF", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := exportSqlite(ctx, db, j); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func exportSqlite(ctx context.Context, db *sql.DB, j *journal.Journal) error {
+	for _, stmt := range sqliteSchema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tripsStmt, err := tx.PrepareContext(ctx, `INSERT INTO trips VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer tripsStmt.Close()
+	stopTimesStmt, err := tx.PrepareContext(ctx, `INSERT INTO stop_times VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stopTimesStmt.Close()
+	for _, trip := range j.Trips {
+		if _, err := tripsStmt.ExecContext(ctx,
+			trip.TripUID,
+			trip.TripID,
+			trip.RouteID,
+			sqliteDirectionID(trip.DirectionID),
+			trip.StartTime.Unix(),
+			trip.VehicleID,
+			trip.LastObserved.Unix(),
+			sqliteTime(trip.MarkedPast),
+			trip.NumUpdates,
+			trip.NumScheduleChanges,
+			trip.NumScheduleRewrites,
+		); err != nil {
+			return fmt.Errorf("failed to insert trip %s: %w", trip.TripUID, err)
+		}
+		for _, stopTime := range trip.StopTimes {
+			if _, err := stopTimesStmt.ExecContext(ctx,
+				trip.TripUID,
+				stopTime.StopID,
+				stopTime.Track,
+				sqliteTime(stopTime.ArrivalTime),
+				sqliteTime(stopTime.DepartureTime),
+				stopTime.LastObserved.Unix(),
+				sqliteTime(stopTime.MarkedPast),
+			); err != nil {
+				return fmt.Errorf("failed to insert stop time for trip %s: %w", trip.TripUID, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, stmt := range sqliteIndexes {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+	return nil
+}
+
+func sqliteTime(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	u := t.Unix()
+	return &u
+}
+
+func sqliteDirectionID(d gtfs.DirectionID) *int64 {
+	b := directionID(d)
+	if b == nil {
+		return nil
+	}
+	var i int64
+	if *b {
+		i = 1
+	}
+	return &i
+}
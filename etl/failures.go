@@ -0,0 +1,120 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+const (
+	defaultFailedDayBackoff  = time.Hour
+	defaultMaxFailedAttempts = 5
+)
+
+// stageError is an error from a specific stage of the pipeline.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e stageError) Error() string {
+	return e.err.Error()
+}
+
+func (e stageError) Unwrap() error {
+	return e.err
+}
+
+// recordFailedDay records a failed attempt to run the pipeline for a day in the metadata.
+func recordFailedDay(ctx context.Context, day metadata.Day, feedIDs []string, runErr error, now time.Time, sc *storage.Client) error {
+	stage := "unknown"
+	var se stageError
+	if errors.As(runErr, &se) {
+		stage = se.stage
+	}
+	return sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		f := m.FailedDay(day)
+		if f == nil {
+			m.FailedDays = append(m.FailedDays, metadata.FailedDay{Day: day})
+			f = &m.FailedDays[len(m.FailedDays)-1]
+		}
+		f.Feeds = feedIDs
+		f.Stage = stage
+		f.Error = runErr.Error()
+		f.Attempts++
+		f.LastAttempt = now
+		return true
+	})
+}
+
+// failedDayRetryTime returns the time after which the backlog may retry a failed day.
+// It returns false if the day is quarantined and should not be retried until its failure record is cleared.
+func failedDayRetryTime(f *metadata.FailedDay, ec *config.Config) (time.Time, bool) {
+	maxAttempts := ec.MaxFailedAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxFailedAttempts
+	}
+	if f.Attempts >= maxAttempts {
+		return time.Time{}, false
+	}
+	backoff := ec.FailedDayBackoff.AsDuration()
+	if backoff <= 0 {
+		backoff = defaultFailedDayBackoff
+	}
+	for i := 1; i < f.Attempts; i++ {
+		backoff *= 2
+	}
+	return f.LastAttempt.Add(backoff), true
+}
+
+// PrintFailedDays prints the days whose most recent run failed and when the backlog will retry them.
+func PrintFailedDays(ctx context.Context, ec *config.Config, sc *storage.Client) error {
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to obtain metadata: %w", err)
+	}
+	for _, f := range m.FailedDays {
+		status := "quarantined"
+		if retryTime, ok := failedDayRetryTime(&f, ec); ok {
+			status = fmt.Sprintf("retry after %s", retryTime.Format(time.RFC3339))
+		}
+		fmt.Printf("%s: %d attempt(s), last at %s, failed in stage %s, %s\n",
+			f.Day, f.Attempts, f.LastAttempt.Format(time.RFC3339), f.Stage, status)
+		fmt.Printf("  feeds: %v\n", f.Feeds)
+		fmt.Printf("  error: %s\n", f.Error)
+	}
+	fmt.Printf("%d failed day(s)\n", len(m.FailedDays))
+	return nil
+}
+
+// ClearFailedDays removes the failure records of the provided days, so that the backlog retries them immediately.
+// If all is true, every failure record is removed.
+func ClearFailedDays(ctx context.Context, days []metadata.Day, all bool, sc *storage.Client) error {
+	var cleared []metadata.Day
+	err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		cleared = nil
+		if all {
+			for _, f := range m.FailedDays {
+				cleared = append(cleared, f.Day)
+			}
+			m.FailedDays = nil
+		} else {
+			for _, day := range days {
+				if m.RemoveFailedDay(day) {
+					cleared = append(cleared, day)
+				}
+			}
+		}
+		return len(cleared) > 0
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	fmt.Printf("Cleared %d failed day(s): %s\n", len(cleared), cleared)
+	return nil
+}
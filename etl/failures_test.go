@@ -0,0 +1,86 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestRecordAndClearFailedDays(t *testing.T) {
+	ctx := context.Background()
+	_, sc := newTestClient(t)
+	jan7 := metadata.NewDay(2022, time.January, 7)
+	jan8 := metadata.NewDay(2022, time.January, 8)
+	for _, runErr := range []error{
+		errors.New("first failure"),
+		stageError{stage: stageUpload, err: errors.New("second failure")},
+	} {
+		if err := recordFailedDay(ctx, jan7, []string{"feedID"}, runErr, time.Now(), sc); err != nil {
+			t.Fatalf("recordFailedDay failed: %s", err)
+		}
+	}
+	if err := recordFailedDay(ctx, jan8, []string{"feedID"}, errors.New("failure"), time.Now(), sc); err != nil {
+		t.Fatalf("recordFailedDay failed: %s", err)
+	}
+
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	f := m.FailedDay(jan7)
+	if f == nil {
+		t.Fatalf("no failure record for %s", jan7)
+	}
+	if f.Attempts != 2 || f.Stage != stageUpload || f.Error != "second failure" {
+		t.Errorf("failure record actual (%d, %s, %s) != expected (2, %s, second failure)", f.Attempts, f.Stage, f.Error, stageUpload)
+	}
+
+	if err := ClearFailedDays(ctx, []metadata.Day{jan7}, false, sc); err != nil {
+		t.Fatalf("ClearFailedDays failed: %s", err)
+	}
+	m, err = sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	var days []metadata.Day
+	for _, f := range m.FailedDays {
+		days = append(days, f.Day)
+	}
+	if want := []metadata.Day{jan8}; !reflect.DeepEqual(days, want) {
+		t.Errorf("failed days actual %v != expected %v", days, want)
+	}
+}
+
+func TestSkipFailedDays(t *testing.T) {
+	ec := &config.Config{FailedDayBackoff: config.Duration(time.Hour), MaxFailedAttempts: 3}
+	now := time.Now()
+	jan7 := metadata.NewDay(2022, time.January, 7)
+	jan8 := metadata.NewDay(2022, time.January, 8)
+	jan9 := metadata.NewDay(2022, time.January, 9)
+	jan10 := metadata.NewDay(2022, time.January, 10)
+	m := &metadata.Metadata{FailedDays: []metadata.FailedDay{
+		// Backing off: the second failure waits two hours.
+		{Day: jan7, Attempts: 2, LastAttempt: now.Add(-90 * time.Minute)},
+		// Ready to retry.
+		{Day: jan8, Attempts: 1, LastAttempt: now.Add(-90 * time.Minute)},
+		// Quarantined.
+		{Day: jan9, Attempts: 3, LastAttempt: now.Add(-24 * time.Hour)},
+	}}
+	var pendingDays []config.PendingDay
+	for _, day := range []metadata.Day{jan7, jan8, jan9, jan10} {
+		pendingDays = append(pendingDays, config.PendingDay{Day: day})
+	}
+
+	var days []metadata.Day
+	for _, pendingDay := range skipFailedDays(pendingDays, m, ec, now) {
+		days = append(days, pendingDay.Day)
+	}
+	if want := []metadata.Day{jan8, jan10}; !reflect.DeepEqual(days, want) {
+		t.Errorf("days actual %v != expected %v", days, want)
+	}
+}
@@ -0,0 +1,108 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+type GarbageCollectOptions struct {
+	// If true, the unreferenced objects are listed but not deleted.
+	DryRun bool
+
+	// Unreferenced objects modified more recently than this are retained.
+	// This prevents deleting the artifacts of a pipeline run that has uploaded them but not yet
+	// updated the metadata.
+	GracePeriod time.Duration
+
+	// Clock to use. If nil, the system clock is used.
+	Clock clock.Clock
+}
+
+// GarbageCollect deletes artifacts in object storage that are not referenced by the metadata or by any of the
+// snapshots in the metadata history, which may be rolled back to.
+//
+// Only objects whose paths have the form of an artifact path are considered;
+// other objects in the bucket, like the metadata itself, are never deleted.
+func GarbageCollect(ctx context.Context, opts GarbageCollectOptions, ec *config.Config, sc *storage.Client) error {
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to obtain metadata: %w", err)
+	}
+	if len(m.ProcessedDays) == 0 {
+		// This is most likely because the metadata has been lost, in which case every artifact
+		// would be deleted.
+		return fmt.Errorf("refusing to garbage collect because the metadata contains no processed days")
+	}
+	referenced := map[string]bool{}
+	addReferences := func(m *metadata.Metadata) {
+		for _, processedDay := range m.ProcessedDays {
+			for _, a := range processedDay.Artifacts {
+				referenced[a.Path] = true
+			}
+		}
+	}
+	addReferences(m)
+	snapshots, err := sc.ListMetadataSnapshots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list metadata snapshots: %w", err)
+	}
+	for _, snapshot := range snapshots {
+		snapshotMetadata, err := sc.GetMetadataSnapshot(ctx, snapshot.Timestamp)
+		if errors.Is(err, storage.ErrNotFound) {
+			// The snapshot was pruned after the snapshots were listed.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read metadata snapshot %s: %w", snapshot.Timestamp, err)
+		}
+		addReferences(snapshotMetadata)
+	}
+
+	objects, err := sc.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	cutoff := clock.OrReal(opts.Clock).Now().Add(-opts.GracePeriod)
+	var unreferenced []storage.ObjectInfo
+	var totalSize int64
+	var numRecent int
+	for _, object := range objects {
+		if referenced[object.Key] {
+			continue
+		}
+		if _, ok := parseArtifactPath(ec, object.Key); !ok {
+			continue
+		}
+		if object.LastModified.After(cutoff) {
+			numRecent++
+			continue
+		}
+		unreferenced = append(unreferenced, object)
+		totalSize += object.Size
+	}
+	fmt.Printf("Found %d unreferenced object(s) totalling %d bytes", len(unreferenced), totalSize)
+	fmt.Printf(" (retaining %d more modified within the grace period of %s)\n", numRecent, opts.GracePeriod)
+	if opts.DryRun {
+		for _, object := range unreferenced {
+			fmt.Printf("  %s (%d bytes, last modified %s)\n", object.Key, object.Size, object.LastModified.Format(time.RFC3339))
+		}
+		fmt.Println("Skipping deletions because dry run mode is on.")
+		return nil
+	}
+	var errs []error
+	for _, object := range unreferenced {
+		if err := sc.Delete(ctx, object.Key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", object.Key, err))
+			continue
+		}
+		fmt.Printf("Deleted %s (%d bytes)\n", object.Key, object.Size)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,91 @@
+package etl
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	day := metadata.NewDay(2022, time.January, 7)
+	referenced := "2022-01/subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz"
+	unreferenced := "2022-01/subwaydatanyc_2022-01-07_csv_bbbbbbbbbbbb.tar.xz"
+	// Only referenced by a previous version of the metadata.
+	inHistory := "2022-01/subwaydatanyc_2022-01-07_csv_cccccccccccc.tar.xz"
+	other := "2022-01/notes.txt"
+	for _, tc := range []struct {
+		name        string
+		opts        GarbageCollectOptions
+		wantObjects []string
+	}{
+		{
+			name:        "dry run",
+			opts:        GarbageCollectOptions{DryRun: true},
+			wantObjects: []string{other, referenced, unreferenced, inHistory, "metadata.json"},
+		},
+		{
+			name:        "within grace period",
+			opts:        GarbageCollectOptions{GracePeriod: time.Hour},
+			wantObjects: []string{other, referenced, unreferenced, inHistory, "metadata.json"},
+		},
+		{
+			name:        "delete",
+			opts:        GarbageCollectOptions{},
+			wantObjects: []string{other, referenced, inHistory, "metadata.json"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ec, sc := newTestClient(t)
+			for _, path := range []string{referenced, unreferenced, inHistory, other} {
+				if err := sc.Write(ctx, strings.NewReader("data"), path); err != nil {
+					t.Fatalf("failed to write object: %s", err)
+				}
+			}
+			for _, path := range []string{inHistory, referenced} {
+				path := path
+				if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+					m.ProcessedDays = []metadata.ProcessedDay{{
+						Day: day,
+						Artifacts: map[string]metadata.Artifact{
+							metadata.ArtifactCsv: {Size: 4, Path: path},
+						},
+					}}
+					return true
+				}); err != nil {
+					t.Fatalf("failed to write metadata: %s", err)
+				}
+			}
+
+			if err := GarbageCollect(ctx, tc.opts, ec, sc); err != nil {
+				t.Fatalf("GarbageCollect failed: %s", err)
+			}
+
+			objects, err := sc.List(ctx, "")
+			if err != nil {
+				t.Fatalf("failed to list objects: %s", err)
+			}
+			var keys []string
+			for _, object := range objects {
+				if strings.HasPrefix(object.Key, "history/") {
+					continue
+				}
+				keys = append(keys, object.Key)
+			}
+			if !reflect.DeepEqual(keys, tc.wantObjects) {
+				t.Errorf("objects actual %v != expected %v", keys, tc.wantObjects)
+			}
+		})
+	}
+}
+
+func TestGarbageCollect_EmptyMetadata(t *testing.T) {
+	ec, sc := newTestClient(t)
+	if err := GarbageCollect(context.Background(), GarbageCollectOptions{}, ec, sc); err == nil {
+		t.Errorf("GarbageCollect with empty metadata succeeded, expected error")
+	}
+}
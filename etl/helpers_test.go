@@ -0,0 +1,31 @@
+package etl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+)
+
+// newTestConfig returns the config of a deployment that stores its data in memory.
+// Tests add the feeds and any other settings they need.
+func newTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	ec := &config.Config{StorageBackend: "memory", MetadataPath: "metadata.json", RemotePrefix: "subwaydatanyc_"}
+	if err := json.Unmarshal([]byte(`"UTC"`), &ec.Timezone); err != nil {
+		t.Fatalf("failed to parse timezone: %s", err)
+	}
+	return ec
+}
+
+// newTestClient returns the config from newTestConfig and a client for its storage.
+func newTestClient(t *testing.T) (*config.Config, *storage.Client) {
+	t.Helper()
+	ec := newTestConfig(t)
+	sc, err := storage.NewClient(ec)
+	if err != nil {
+		t.Fatalf("failed to create storage client: %s", err)
+	}
+	return ec, sc
+}
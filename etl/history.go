@@ -0,0 +1,75 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+// PrintMetadataHistory prints the snapshots of the metadata, from oldest to newest.
+func PrintMetadataHistory(ctx context.Context, sc *storage.Client) error {
+	snapshots, err := sc.ListMetadataSnapshots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list metadata snapshots: %w", err)
+	}
+	for _, snapshot := range snapshots {
+		fmt.Printf("%s (%d bytes)\n", snapshot.Timestamp, snapshot.Size)
+	}
+	fmt.Printf("%d snapshot(s)\n", len(snapshots))
+	return nil
+}
+
+// RollbackMetadata replaces the metadata with the snapshot that has the provided timestamp.
+//
+// The rollback is itself committed as a new version of the metadata, so it can be undone by rolling back
+// to the snapshot that preceded it. The rollback is refused if any artifact referenced by the snapshot no
+// longer exists in object storage.
+func RollbackMetadata(ctx context.Context, timestamp string, dryRun bool, sc *storage.Client) error {
+	snapshot, err := sc.GetMetadataSnapshot(ctx, timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata snapshot: %w", err)
+	}
+	var missing []string
+	for _, processedDay := range snapshot.ProcessedDays {
+		for _, name := range sortedKeys(processedDay.Artifacts) {
+			a := processedDay.Artifacts[name]
+			_, err := sc.Stat(ctx, a.Path)
+			if errors.Is(err, storage.ErrNotFound) {
+				missing = append(missing, a.Path)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to stat %s: %w", a.Path, err)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("refusing to roll back to %s because %d referenced artifact(s) no longer exist: %s",
+			timestamp, len(missing), strings.Join(missing, ", "))
+	}
+	var changes []string
+	var months map[string]bool
+	err = sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		changes = diffMetadata(m, snapshot)
+		months = changedMonths(m, snapshot)
+		*m = *snapshot
+		return !dryRun
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+	fmt.Printf("%d change(s) from rolling back to %s:\n", len(changes), timestamp)
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if dryRun {
+		fmt.Println("Skipping the rollback because dry run mode is on.")
+		return nil
+	}
+	fmt.Printf("Rolled back the metadata to %s.\n", timestamp)
+	return writeSha256Sums(ctx, months, sc)
+}
@@ -0,0 +1,107 @@
+package etl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestRollbackMetadata(t *testing.T) {
+	ctx := context.Background()
+	_, sc := newTestClient(t)
+	for _, version := range []int{1, 2} {
+		version := version
+		if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+			m.ProcessedDays = []metadata.ProcessedDay{{
+				Day:             metadata.NewDay(2022, time.January, 7),
+				SoftwareVersion: version,
+			}}
+			return true
+		}); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+	}
+	snapshots, err := sc.ListMetadataSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+
+	softwareVersion := func() int {
+		m, err := sc.GetMetadata(ctx)
+		if err != nil {
+			t.Fatalf("failed to read metadata: %s", err)
+		}
+		return m.ProcessedDays[0].SoftwareVersion
+	}
+	if err := RollbackMetadata(ctx, snapshots[0].Timestamp, true, sc); err != nil {
+		t.Fatalf("RollbackMetadata failed: %s", err)
+	}
+	if v := softwareVersion(); v != 2 {
+		t.Errorf("software version after dry run actual %d != expected 2", v)
+	}
+	if err := RollbackMetadata(ctx, snapshots[0].Timestamp, false, sc); err != nil {
+		t.Fatalf("RollbackMetadata failed: %s", err)
+	}
+	if v := softwareVersion(); v != 1 {
+		t.Errorf("software version after rollback actual %d != expected 1", v)
+	}
+
+	// The rollback should itself be recorded in the history so that it can be undone.
+	snapshots, err = sc.ListMetadataSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+	if len(snapshots) != 3 {
+		t.Errorf("number of snapshots actual %d != expected 3", len(snapshots))
+	}
+}
+
+func TestRollbackMetadata_MissingArtifact(t *testing.T) {
+	ctx := context.Background()
+	_, sc := newTestClient(t)
+	path := "2022-01/subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz"
+	for _, artifacts := range []map[string]metadata.Artifact{
+		{metadata.ArtifactCsv: {Size: 4, Path: path, Sha256: "aaaaaaaaaaaa1111"}},
+		nil,
+	} {
+		artifacts := artifacts
+		if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+			m.ProcessedDays = []metadata.ProcessedDay{{
+				Day:       metadata.NewDay(2022, time.January, 7),
+				Artifacts: artifacts,
+			}}
+			return true
+		}); err != nil {
+			t.Fatalf("failed to write metadata: %s", err)
+		}
+	}
+	snapshots, err := sc.ListMetadataSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+
+	if err := RollbackMetadata(ctx, snapshots[0].Timestamp, false, sc); err == nil {
+		t.Errorf("RollbackMetadata to a snapshot with a missing artifact succeeded, expected error")
+	}
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	if m.ProcessedDays[0].Artifacts != nil {
+		t.Errorf("metadata was rolled back to a snapshot with a missing artifact")
+	}
+
+	if err := sc.Write(ctx, strings.NewReader("data"), path); err != nil {
+		t.Fatalf("failed to write object: %s", err)
+	}
+	if err := RollbackMetadata(ctx, snapshots[0].Timestamp, false, sc); err != nil {
+		t.Errorf("RollbackMetadata failed: %s", err)
+	}
+	want := "aaaaaaaaaaaa1111  subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz\n"
+	if got := readObject(t, sc, "2022-01/SHA256SUMS"); got != want {
+		t.Errorf("SHA256SUMS after rollback actual %q != expected %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+// Package monitoring contains the Prometheus metrics exported by the ETL pipeline.
+package monitoring
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "subwaydatanyc"
+
+// Registry contains all of the metrics of the pipeline.
+// A dedicated registry is used so that metrics pushed from one-shot commands contain only pipeline metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	stageDuration = newHistogramVec(prometheus.HistogramOpts{
+		Name:    "stage_duration_seconds",
+		Help:    "Duration of each stage of the pipeline for a single day.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"stage"})
+	downloadedBytes = newCounter(prometheus.CounterOpts{
+		Name: "downloaded_bytes_total",
+		Help: "Number of bytes of raw data downloaded from Hoard.",
+	})
+	uploadedBytes = newCounter(prometheus.CounterOpts{
+		Name: "uploaded_bytes_total",
+		Help: "Number of bytes of artifacts uploaded to object storage.",
+	})
+	tripsPerDay = newHistogram(prometheus.HistogramOpts{
+		Name:    "trips_per_day",
+		Help:    "Number of trips in the data produced for each day.",
+		Buckets: prometheus.ExponentialBuckets(1000, 2, 6),
+	})
+	stopTimesPerDay = newHistogram(prometheus.HistogramOpts{
+		Name:    "stop_times_per_day",
+		Help:    "Number of stop times in the data produced for each day.",
+		Buckets: prometheus.ExponentialBuckets(50000, 2, 8),
+	})
+	backlogSize = newGauge(prometheus.GaugeOpts{
+		Name: "backlog_size",
+		Help: "Number of days in the backlog that have yet to be processed.",
+	})
+	failures = newCounterVec(prometheus.CounterOpts{
+		Name: "failures_total",
+		Help: "Number of failed runs of the pipeline, by the stage that failed.",
+	}, []string{"stage"})
+	lastSuccess = newGauge(prometheus.GaugeOpts{
+		Name: "last_success_timestamp_seconds",
+		Help: "Unix time at which the pipeline last succeeded for a day.",
+	})
+)
+
+func RecordStageDuration(stage string, d time.Duration) {
+	stageDuration.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+func RecordDownload(numBytes int64) {
+	downloadedBytes.Add(float64(numBytes))
+}
+
+func RecordUpload(numBytes int64) {
+	uploadedBytes.Add(float64(numBytes))
+}
+
+// RecordSuccess records that the pipeline succeeded for a day that contains the provided number of trips
+// and stop times.
+func RecordSuccess(t time.Time, numTrips, numStopTimes int) {
+	tripsPerDay.Observe(float64(numTrips))
+	stopTimesPerDay.Observe(float64(numStopTimes))
+	lastSuccess.Set(float64(t.Unix()))
+}
+
+func RecordFailure(stage string) {
+	failures.WithLabelValues(stage).Inc()
+}
+
+func SetBacklogSize(n int) {
+	backlogSize.Set(float64(n))
+}
+
+// Handler returns an HTTP handler that serves the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Push pushes the metrics to a Pushgateway-compatible endpoint, replacing metrics previously pushed with the
+// same job name.
+func Push(url, job string) error {
+	if err := push.New(url, job).Gatherer(Registry).Push(); err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %w", url, err)
+	}
+	return nil
+}
+
+func newCounter(opts prometheus.CounterOpts) prometheus.Counter {
+	opts.Namespace = namespace
+	c := prometheus.NewCounter(opts)
+	Registry.MustRegister(c)
+	return c
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	opts.Namespace = namespace
+	c := prometheus.NewCounterVec(opts, labels)
+	Registry.MustRegister(c)
+	return c
+}
+
+func newGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	opts.Namespace = namespace
+	g := prometheus.NewGauge(opts)
+	Registry.MustRegister(g)
+	return g
+}
+
+func newHistogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	opts.Namespace = namespace
+	h := prometheus.NewHistogram(opts)
+	Registry.MustRegister(h)
+	return h
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	opts.Namespace = namespace
+	h := prometheus.NewHistogramVec(opts, labels)
+	Registry.MustRegister(h)
+	return h
+}
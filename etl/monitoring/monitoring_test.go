@@ -0,0 +1,39 @@
+package monitoring
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordFailure(t *testing.T) {
+	before := testutil.ToFloat64(failures.WithLabelValues("upload"))
+	RecordFailure("upload")
+	if got := testutil.ToFloat64(failures.WithLabelValues("upload")); got != before+1 {
+		t.Errorf("failures actual %v != expected %v", got, before+1)
+	}
+}
+
+func TestPush(t *testing.T) {
+	SetBacklogSize(3)
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(b)
+	}))
+	defer server.Close()
+
+	if err := Push(server.URL, "test_job"); err != nil {
+		t.Fatalf("Push failed: %s", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/test_job" {
+		t.Errorf("request actual %s %s != expected PUT /metrics/job/test_job", method, path)
+	}
+	if !strings.Contains(body, "subwaydatanyc_backlog_size") {
+		t.Errorf("pushed metrics do not contain the backlog size")
+	}
+}
@@ -0,0 +1,254 @@
+package periodic
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl"
+	"github.com/jamespfennell/subwaydata.nyc/etl/monitoring"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+const (
+	triggerSchedule = "schedule"
+	triggerManual   = "manual"
+)
+
+// Controller is used to observe and control the periodic runner while it is running.
+//
+// Its HTTP handler serves the following endpoints:
+//
+//	GET  /status   the next scheduled window, the backlog run in progress and the result of the last run
+//	POST /trigger  run the backlog now, until the end of the current or next scheduled window
+//	POST /pause    stop starting scheduled runs; a run in progress is not affected
+//	POST /resume   start scheduled runs again, including a window that opened while paused if it is still open
+//	GET  /metrics  the Prometheus metrics of the pipeline
+type Controller struct {
+	trigger  chan struct{}
+	resumed  chan struct{}
+	progress *etl.Progress
+
+	m            sync.Mutex
+	paused       bool
+	pausedWindow *Window
+	ticker       *Ticker
+	current      *RunStatus
+	last         *RunStatus
+}
+
+func NewController() *Controller {
+	return &Controller{
+		trigger:  make(chan struct{}, 1),
+		resumed:  make(chan struct{}, 1),
+		progress: etl.NewProgress(),
+	}
+}
+
+// Status is the status of the periodic runner.
+type Status struct {
+	Paused bool `json:"paused"`
+
+	// The next scheduled window, or nil if there are no more windows.
+	NextWindow *WindowStatus `json:"nextWindow"`
+
+	// The last scheduled window that opened while scheduled runs were paused, if it has not been run.
+	// It is run if scheduled runs are resumed before it closes.
+	PausedWindow *WindowStatus `json:"pausedWindow,omitempty"`
+
+	// The backlog run in progress, or nil if the backlog is not running.
+	Current *RunStatus `json:"current"`
+
+	// The last backlog run that finished, or nil if no run has finished.
+	Last *RunStatus `json:"last"`
+}
+
+type WindowStatus struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// RunStatus describes a single run of the backlog.
+type RunStatus struct {
+	// Either "schedule" or "manual".
+	Trigger string `json:"trigger"`
+
+	Start time.Time `json:"start"`
+
+	// When the run is stopped. Manually triggered runs are stopped at the end of the current or next scheduled
+	// window, and have no deadline if there are no more scheduled windows.
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	// The days that are running and their stages. Only set for the run in progress.
+	Running []etl.RunningDay `json:"running,omitempty"`
+
+	// The following fields are only set once the run has finished.
+	End       *time.Time     `json:"end,omitempty"`
+	Succeeded []metadata.Day `json:"succeeded,omitempty"`
+	Failed    []metadata.Day `json:"failed,omitempty"`
+	Deferred  []metadata.Day `json:"deferred,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// Status returns the current status of the periodic runner.
+func (c *Controller) Status() Status {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status := Status{Paused: c.paused, Last: c.last}
+	if c.ticker != nil {
+		if window, ok := c.ticker.Next(); ok {
+			status.NextWindow = &WindowStatus{Start: window.Start, End: window.End}
+		}
+	}
+	if c.pausedWindow != nil {
+		status.PausedWindow = &WindowStatus{Start: c.pausedWindow.Start, End: c.pausedWindow.End}
+	}
+	if c.current != nil {
+		current := *c.current
+		current.Running = c.progress.Running()
+		status.Current = &current
+	}
+	return status
+}
+
+// Trigger requests that the backlog is run as soon as possible.
+// It returns false if the backlog is already running or a run has already been requested.
+func (c *Controller) Trigger() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.current != nil {
+		return false
+	}
+	select {
+	case c.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetPaused pauses or resumes scheduled runs.
+func (c *Controller) SetPaused(paused bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.paused && !paused {
+		select {
+		case c.resumed <- struct{}{}:
+		default:
+		}
+	}
+	c.paused = paused
+}
+
+// skipWindow records that the window was not run because scheduled runs are paused.
+// It returns false if scheduled runs are not paused, in which case the window should be run.
+func (c *Controller) skipWindow(window Window) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if !c.paused {
+		return false
+	}
+	c.pausedWindow = &window
+	return true
+}
+
+// takePausedWindow returns the window that was skipped while scheduled runs were paused, if scheduled runs
+// are no longer paused.
+func (c *Controller) takePausedWindow() (Window, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.paused || c.pausedWindow == nil {
+		return Window{}, false
+	}
+	window := *c.pausedWindow
+	c.pausedWindow = nil
+	return window, true
+}
+
+func (c *Controller) setTicker(ticker *Ticker) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.ticker = ticker
+}
+
+func (c *Controller) startRun(trigger string, start, deadline time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.current = &RunStatus{Trigger: trigger, Start: start}
+	if !deadline.IsZero() {
+		c.current.Deadline = &deadline
+	}
+}
+
+func (c *Controller) finishRun(end time.Time, result *etl.BacklogResult, err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	last := c.current
+	c.current = nil
+	if last == nil {
+		return
+	}
+	last.End = &end
+	if result != nil {
+		last.Succeeded = result.Succeeded
+		last.Failed = result.Failed
+		last.Deferred = result.Deferred
+	}
+	if err != nil {
+		last.Error = err.Error()
+	}
+	c.last = last
+}
+
+// Handler returns an HTTP handler for the control endpoints.
+func (c *Controller) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", monitoring.Handler())
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		c.writeStatus(rw, http.StatusOK)
+	})
+	mux.HandleFunc("/trigger", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !c.Trigger() {
+			c.writeStatus(rw, http.StatusConflict)
+			return
+		}
+		log.Printf("Backlog run triggered via the control API")
+		c.writeStatus(rw, http.StatusAccepted)
+	})
+	for path, paused := range map[string]bool{"/pause": true, "/resume": false} {
+		paused := paused
+		mux.HandleFunc(path, func(rw http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				rw.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			c.SetPaused(paused)
+			log.Printf("Scheduled runs paused=%t via the control API", paused)
+			c.writeStatus(rw, http.StatusOK)
+		})
+	}
+	return mux
+}
+
+func (c *Controller) writeStatus(rw http.ResponseWriter, statusCode int) {
+	b, err := json.MarshalIndent(c.Status(), "", "  ")
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	if _, err := rw.Write(b); err != nil {
+		log.Printf("Failed to write response: %s", err)
+	}
+}
@@ -0,0 +1,157 @@
+package periodic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl"
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestController(t *testing.T) {
+	c := NewController()
+	server := httptest.NewServer(c.Handler())
+	defer server.Close()
+
+	request := func(method, path string, wantStatusCode int) Status {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %s", method, path, err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != wantStatusCode {
+			t.Fatalf("%s %s status code actual %d != expected %d", method, path, res.StatusCode, wantStatusCode)
+		}
+		var status Status
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+				t.Fatalf("failed to decode status: %s", err)
+			}
+		}
+		return status
+	}
+
+	if status := request(http.MethodGet, "/status", http.StatusOK); !reflect.DeepEqual(status, Status{}) {
+		t.Errorf("initial status actual %+v != expected %+v", status, Status{})
+	}
+	request(http.MethodGet, "/pause", http.StatusMethodNotAllowed)
+	if status := request(http.MethodPost, "/pause", http.StatusOK); !status.Paused {
+		t.Errorf("status after pause is not paused")
+	}
+	if status := request(http.MethodPost, "/resume", http.StatusOK); status.Paused {
+		t.Errorf("status after resume is paused")
+	}
+
+	request(http.MethodPost, "/trigger", http.StatusAccepted)
+	// A run has already been requested.
+	request(http.MethodPost, "/trigger", http.StatusConflict)
+	<-c.trigger
+
+	day := metadata.NewDay(2022, time.January, 7)
+	start := time.Date(2022, time.January, 8, 5, 0, 0, 0, time.UTC)
+	c.startRun(triggerManual, start, time.Time{})
+	status := request(http.MethodGet, "/status", http.StatusOK)
+	wantCurrent := &RunStatus{
+		Trigger: triggerManual,
+		Start:   start,
+	}
+	if !reflect.DeepEqual(status.Current, wantCurrent) {
+		t.Errorf("current run actual %+v != expected %+v", status.Current, wantCurrent)
+	}
+	// The backlog is already running.
+	request(http.MethodPost, "/trigger", http.StatusConflict)
+
+	end := start.Add(time.Hour)
+	c.finishRun(end, &etl.BacklogResult{Failed: []metadata.Day{day}}, errors.New("failure"))
+	status = request(http.MethodGet, "/status", http.StatusOK)
+	wantLast := &RunStatus{
+		Trigger: triggerManual,
+		Start:   start,
+		End:     &end,
+		Failed:  []metadata.Day{day},
+		Error:   "failure",
+	}
+	if status.Current != nil || !reflect.DeepEqual(status.Last, wantLast) {
+		t.Errorf("status actual (%+v, %+v) != expected (nil, %+v)", status.Current, status.Last, wantLast)
+	}
+}
+
+func TestRun_ResumeAndTrigger(t *testing.T) {
+	ec := &config.Config{StorageBackend: "memory", MetadataPath: "metadata.json"}
+	if err := json.Unmarshal([]byte(`"UTC"`), &ec.Timezone); err != nil {
+		t.Fatalf("failed to parse timezone: %s", err)
+	}
+	sc, err := storage.NewClient(ec)
+	if err != nil {
+		t.Fatalf("failed to create storage client: %s", err)
+	}
+	s, err := ParseSchedule("10:00:00-11:00:00", time.UTC)
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	clk := clock.NewFake(time.Date(2022, time.January, 7, 9, 0, 0, 0, time.UTC))
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+	c := NewController()
+	c.SetPaused(true)
+	go run(ctx, clk, ec, nil, sc, []Schedule{s}, c)
+
+	waitForStatus := func(description string, f func(Status) bool) Status {
+		t.Helper()
+		for {
+			if status := c.Status(); f(status) {
+				return status
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for %s", description)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+
+	// The window opens while scheduled runs are paused.
+	if err := clk.WaitForTimers(ctx, 1); err != nil {
+		t.Fatalf("WaitForTimers failed: %s", err)
+	}
+	clk.Set(time.Date(2022, time.January, 7, 10, 30, 0, 0, time.UTC))
+	status := waitForStatus("the window to be skipped", func(s Status) bool { return s.PausedWindow != nil })
+	if status.Last != nil {
+		t.Errorf("backlog ran while scheduled runs were paused: %+v", status.Last)
+	}
+
+	// The window is still open when scheduled runs are resumed, so it is run.
+	c.SetPaused(false)
+	status = waitForStatus("the window to be run", func(s Status) bool { return s.Last != nil })
+	wantDeadline := time.Date(2022, time.January, 7, 11, 0, 0, 0, time.UTC)
+	if status.Last.Trigger != triggerSchedule || status.Last.Deadline == nil || !status.Last.Deadline.Equal(wantDeadline) {
+		t.Errorf("run actual (%s, %v) != expected (%s, %s)", status.Last.Trigger, status.Last.Deadline, triggerSchedule, wantDeadline)
+	}
+	if status.PausedWindow != nil {
+		t.Errorf("paused window actual %+v != expected nil", status.PausedWindow)
+	}
+
+	// A manually triggered run is stopped at the end of the next window.
+	if !c.Trigger() {
+		t.Fatalf("Trigger() actual false != expected true")
+	}
+	status = waitForStatus("the triggered run", func(s Status) bool { return s.Last.Trigger == triggerManual })
+	wantDeadline = time.Date(2022, time.January, 8, 11, 0, 0, 0, time.UTC)
+	if status.Last.Deadline == nil || !status.Last.Deadline.Equal(wantDeadline) {
+		t.Errorf("deadline actual %v != expected %s", status.Last.Deadline, wantDeadline)
+	}
+}
@@ -0,0 +1,246 @@
+package periodic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a schedule described by a standard five field cron expression:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field is a comma separated list of terms, where a term is *, a value, or a range a-b, optionally
+// followed by a step /n. Months and days of the week may also be given by their three letter English names,
+// and both 0 and 7 denote Sunday. As in other cron implementations, if both the day of month and day of
+// week are restricted, a day matches if either of them matches.
+//
+// The expression may be prefixed by CRON_TZ=<timezone> to evaluate it in a timezone other than the default,
+// and the macros @yearly, @monthly, @weekly, @daily and @hourly may be used in place of the five fields.
+//
+// Times are evaluated on the wall clock of the timezone. If a time occurs twice because clocks go back, it
+// matches only its first occurrence. If a time is skipped because clocks go forward, it matches the first
+// instant after the skipped period.
+type CronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+
+	// Whether the day of month and day of week fields are restricted, i.e. are not *.
+	dayOfMonthRestricted, dayOfWeekRestricted bool
+
+	loc *time.Location
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField     = cronField{name: "minute", min: 0, max: 59}
+	hourField       = cronField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = cronField{name: "day of month", min: 1, max: 31}
+	monthField      = cronField{name: "month", min: 1, max: 12, names: []string{
+		"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Day of week 7 is Sunday, and is folded into 0 after parsing.
+	dayOfWeekField = cronField{name: "day of week", min: 0, max: 7, names: []string{
+		"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// ParseCronSchedule parses a cron expression. The expression is evaluated in loc unless it specifies a
+// timezone using the CRON_TZ prefix.
+func ParseCronSchedule(expr string, loc *time.Location) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if rest, ok := strings.CutPrefix(expr, "CRON_TZ="); ok {
+		name, fields, _ := strings.Cut(rest, " ")
+		var err error
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q in cron expression: %w", name, err)
+		}
+		expr = strings.TrimSpace(fields)
+	}
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q has %d fields, expected 5", expr, len(fields))
+	}
+	s := &CronSchedule{loc: loc}
+	var err error
+	for i, f := range []struct {
+		field cronField
+		bits  *uint64
+	}{
+		{minuteField, &s.minute},
+		{hourField, &s.hour},
+		{dayOfMonthField, &s.dayOfMonth},
+		{monthField, &s.month},
+		{dayOfWeekField, &s.dayOfWeek},
+	} {
+		*f.bits, err = f.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+	if s.dayOfWeek&(1<<7) != 0 {
+		s.dayOfWeek = s.dayOfWeek&^(1<<7) | 1
+	}
+	s.dayOfMonthRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dayOfWeekRestricted = !strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parse returns a bitset with a bit set for each value matched by the field.
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, term := range strings.Split(s, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(term, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepStr, f.name)
+			}
+		}
+		var lo, hi int
+		switch {
+		case rangeStr == "*":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangeStr, "-"):
+			loStr, hiStr, _ := strings.Cut(rangeStr, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(hiStr); err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangeStr, f.name)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rangeStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			// As in other cron implementations, a/n means every n starting from a.
+			if hasStep {
+				hi = f.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field (must be between %d and %d)", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule.
+// It returns the zero time if no time in the next five years matches, e.g. for the expression 0 0 30 2 *.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	w := wallClock(t, s.loc).Truncate(time.Minute).Add(time.Minute)
+	limit := w.AddDate(5, 0, 0)
+	for w.Before(limit) {
+		w = s.nextMatch(w, limit)
+		if w.IsZero() {
+			break
+		}
+		if r := resolveWallClock(w, s.loc); r.After(t) {
+			return r
+		}
+		w = w.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// nextMatch returns the first wall clock time at or after w that matches the schedule, or the zero time if
+// there is no match before limit. Wall clock times are represented as times in UTC.
+func (s *CronSchedule) nextMatch(w, limit time.Time) time.Time {
+	for w.Before(limit) {
+		switch {
+		case s.month&(1<<uint(w.Month())) == 0:
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(w):
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(w.Hour())) == 0:
+			w = w.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(w.Minute())) == 0:
+			w = w.Add(time.Minute)
+		default:
+			return w
+		}
+	}
+	return time.Time{}
+}
+
+func (s *CronSchedule) matchesDay(w time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(w.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(w.Weekday())) != 0
+	if s.dayOfMonthRestricted && s.dayOfWeekRestricted {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+// wallClock returns the wall clock time of t in loc, represented as a time in UTC.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// resolveWallClock returns the first instant at which the wall clock in loc shows w, which is represented as
+// a time in UTC. If the wall clock skips w because clocks go forward, the instant the clocks went forward is
+// returned.
+func resolveWallClock(w time.Time, loc *time.Location) time.Time {
+	// Zone transitions are at least days apart, so the offsets a day either side of w are the offsets in
+	// effect before and after any transition near w.
+	_, offsetBefore := w.Add(-24 * time.Hour).In(loc).Zone()
+	_, offsetAfter := w.Add(24 * time.Hour).In(loc).Zone()
+	var first time.Time
+	for _, offset := range []int{offsetBefore, offsetAfter} {
+		r := w.Add(-time.Duration(offset) * time.Second)
+		if wallClock(r, loc).Equal(w) && (first.IsZero() || r.Before(first)) {
+			first = r
+		}
+	}
+	if !first.IsZero() {
+		return first.In(loc)
+	}
+	// w is in a gap. Interpreted with the offset from before the gap, w is an instant after the transition;
+	// step back from it to find the transition.
+	r := w.Add(-time.Duration(offsetBefore) * time.Second).Truncate(time.Minute)
+	for {
+		if _, offset := r.Add(-time.Minute).In(loc).Zone(); offset == offsetBefore {
+			return r.In(loc)
+		}
+		r = r.Add(-time.Minute)
+	}
+}
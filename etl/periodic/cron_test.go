@@ -0,0 +1,79 @@
+package periodic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	utc := time.UTC
+	// Friday.
+	base := time.Date(2022, time.January, 7, 10, 17, 30, 0, utc)
+	for _, tc := range []struct {
+		expr string
+		t    time.Time
+		want time.Time
+	}{
+		{"* * * * *", base, time.Date(2022, time.January, 7, 10, 18, 0, 0, utc)},
+		{"*/15 * * * *", base, time.Date(2022, time.January, 7, 10, 30, 0, 0, utc)},
+		{"5/20 * * * *", base, time.Date(2022, time.January, 7, 10, 25, 0, 0, utc)},
+		{"0 * * * *", base, time.Date(2022, time.January, 7, 11, 0, 0, 0, utc)},
+		{"@hourly", base, time.Date(2022, time.January, 7, 11, 0, 0, 0, utc)},
+		{"30 2 * * *", base, time.Date(2022, time.January, 8, 2, 30, 0, 0, utc)},
+		{"0 9-17/4 * * *", base, time.Date(2022, time.January, 7, 13, 0, 0, 0, utc)},
+		{"0 2 * * MON-FRI", base, time.Date(2022, time.January, 10, 2, 0, 0, 0, utc)},
+		{"0 2 * * 1-5", base, time.Date(2022, time.January, 10, 2, 0, 0, 0, utc)},
+		{"0 0 * * 7", base, time.Date(2022, time.January, 9, 0, 0, 0, 0, utc)},
+		{"0 0 * * sun", base, time.Date(2022, time.January, 9, 0, 0, 0, 0, utc)},
+		{"0 0 1,15 * *", base, time.Date(2022, time.January, 15, 0, 0, 0, 0, utc)},
+		{"0 0 1 feb *", base, time.Date(2022, time.February, 1, 0, 0, 0, 0, utc)},
+		// Both the day of month and day of week are restricted, so either can match.
+		{"0 0 20 * MON", base, time.Date(2022, time.January, 10, 0, 0, 0, 0, utc)},
+		{"0 0 29 2 *", base, time.Date(2024, time.February, 29, 0, 0, 0, 0, utc)},
+		// The time itself doesn't match; only later times do.
+		{"17 10 * * *", time.Date(2022, time.January, 7, 10, 17, 0, 0, utc), time.Date(2022, time.January, 8, 10, 17, 0, 0, utc)},
+		{"0 0 30 2 *", base, time.Time{}},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := ParseCronSchedule(tc.expr, utc)
+			if err != nil {
+				t.Fatalf("ParseCronSchedule(%q) failed: %s", tc.expr, err)
+			}
+			if got := s.Next(tc.t); !got.Equal(tc.want) {
+				t.Errorf("Next(%s) actual %s != expected %s", tc.t, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCronSchedule_Timezone(t *testing.T) {
+	s, err := ParseCronSchedule("CRON_TZ=Asia/Tokyo 0 9 * * *", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseCronSchedule failed: %s", err)
+	}
+	got := s.Next(time.Date(2022, time.January, 7, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2022, time.January, 8, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next actual %s != expected %s", got, want)
+	}
+}
+
+func TestParseCronSchedule_Errors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"CRON_TZ=Not/AZone * * * * *",
+	} {
+		if _, err := ParseCronSchedule(expr, time.UTC); err == nil {
+			t.Errorf("ParseCronSchedule(%q) succeeded, expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package periodic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+)
+
+// In America/New_York, clocks went forward from 02:00 EST to 03:00 EDT on 2022-03-13, and went back from
+// 02:00 EDT to 01:00 EST on 2022-11-06.
+
+func newYork(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %s", err)
+	}
+	return loc
+}
+
+func instant(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2022, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestSchedule_DST(t *testing.T) {
+	loc := newYork(t)
+	for _, tc := range []struct {
+		name     string
+		schedule string
+		t        time.Time
+		want     Window
+	}{
+		{
+			"spring forward: cron time in the gap runs when clocks go forward",
+			"30 2 * * * for 1h",
+			instant(time.March, 12, 17, 0),
+			Window{instant(time.March, 13, 7, 0), instant(time.March, 13, 8, 0)},
+		},
+		{
+			"spring forward: cron runs at the same wall clock time the next day",
+			"30 2 * * * for 1h",
+			instant(time.March, 13, 8, 0),
+			Window{instant(time.March, 14, 6, 30), instant(time.March, 14, 7, 30)},
+		},
+		{
+			"spring forward: cron window without a duration is 23 hours long",
+			"0 12 * * *",
+			instant(time.March, 12, 18, 0),
+			Window{instant(time.March, 12, 17, 0), instant(time.March, 13, 16, 0)},
+		},
+		{
+			"spring forward: interval is an hour shorter",
+			"01:00:00-03:00:00",
+			instant(time.March, 12, 17, 0),
+			Window{instant(time.March, 13, 6, 0), instant(time.March, 13, 7, 0)},
+		},
+		{
+			"spring forward: interval starting in the gap starts when clocks go forward",
+			"02:30:00-04:00:00",
+			instant(time.March, 12, 17, 0),
+			Window{instant(time.March, 13, 7, 0), instant(time.March, 13, 8, 0)},
+		},
+		{
+			"spring forward: interval spanning midnight",
+			"23:00:00-04:00:00",
+			instant(time.March, 12, 17, 0),
+			Window{instant(time.March, 13, 4, 0), instant(time.March, 13, 8, 0)},
+		},
+		{
+			"fall back: cron time that occurs twice runs at its first occurrence",
+			"30 1 * * * for 1h",
+			instant(time.November, 5, 16, 0),
+			Window{instant(time.November, 6, 5, 30), instant(time.November, 6, 6, 30)},
+		},
+		{
+			"fall back: cron time that occurs twice does not run at its second occurrence",
+			"30 1 * * * for 1h",
+			instant(time.November, 6, 6, 30),
+			Window{instant(time.November, 7, 6, 30), instant(time.November, 7, 7, 30)},
+		},
+		{
+			"fall back: interval is an hour longer",
+			"01:00:00-03:00:00",
+			instant(time.November, 5, 16, 0),
+			Window{instant(time.November, 6, 5, 0), instant(time.November, 6, 8, 0)},
+		},
+		{
+			"fall back: interval spanning midnight ends at the first occurrence of its end",
+			"23:00:00-01:30:00",
+			instant(time.November, 5, 16, 0),
+			Window{instant(time.November, 6, 3, 0), instant(time.November, 6, 5, 30)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := ParseSchedule(tc.schedule, loc)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) failed: %s", tc.schedule, err)
+			}
+			got, ok := s.Next(tc.t)
+			if !ok || !got.Start.Equal(tc.want.Start) || !got.End.Equal(tc.want.End) {
+				t.Errorf("Next(%s) actual (%v, %t) != expected %v", tc.t, got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestTicker_DST(t *testing.T) {
+	loc := newYork(t)
+	for _, tc := range []struct {
+		name     string
+		schedule string
+		start    time.Time
+		want     []Window
+	}{
+		{
+			"spring forward",
+			"30 2 * * * for 1h",
+			instant(time.March, 12, 17, 0),
+			[]Window{
+				{instant(time.March, 13, 7, 0), instant(time.March, 13, 8, 0)},
+				{instant(time.March, 14, 6, 30), instant(time.March, 14, 7, 30)},
+			},
+		},
+		{
+			// The ticker starts inside the first window, which is sent immediately to catch up.
+			"fall back",
+			"30 1 * * * for 1h",
+			instant(time.November, 6, 5, 45),
+			[]Window{
+				{instant(time.November, 6, 5, 30), instant(time.November, 6, 6, 30)},
+				{instant(time.November, 7, 6, 30), instant(time.November, 7, 7, 30)},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancelFunc()
+			s, err := ParseSchedule(tc.schedule, loc)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) failed: %s", tc.schedule, err)
+			}
+			clk := clock.NewFake(tc.start)
+			ticker := NewTicker([]Schedule{s}, clk)
+			defer ticker.Stop()
+
+			for _, want := range tc.want {
+				if clk.Now().Before(want.Start) {
+					if err := clk.WaitForTimers(ctx, 1); err != nil {
+						t.Fatalf("ticker did not wait for %v: %s", want, err)
+					}
+					clk.Set(want.Start)
+				}
+				select {
+				case got := <-ticker.C:
+					if !got.Start.Equal(want.Start) || !got.End.Equal(want.End) {
+						t.Errorf("window actual %v != expected %v", got, want)
+					}
+				case <-ctx.Done():
+					t.Fatalf("ticker did not send %v", want)
+				}
+			}
+		})
+	}
+}
+
+func TestTicker_SkipsClosedWindows(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+	s, err := ParseSchedule("30 2 * * * for 1h", newYork(t))
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %s", err)
+	}
+	clk := clock.NewFake(instant(time.March, 13, 6, 0))
+	ticker := NewTicker([]Schedule{s}, clk)
+	defer ticker.Stop()
+
+	if err := clk.WaitForTimers(ctx, 1); err != nil {
+		t.Fatalf("ticker did not wait for the first window: %s", err)
+	}
+	// The clock jumps past the end of the first window, e.g. because the machine was suspended.
+	clk.Set(instant(time.March, 13, 8, 30))
+	if err := clk.WaitForTimers(ctx, 1); err != nil {
+		t.Fatalf("ticker did not wait for the second window: %s", err)
+	}
+	clk.Set(instant(time.March, 14, 6, 30))
+	select {
+	case got := <-ticker.C:
+		if want := instant(time.March, 14, 6, 30); !got.Start.Equal(want) {
+			t.Errorf("window start actual %s != expected %s", got.Start, want)
+		}
+	case <-ctx.Done():
+		t.Fatalf("ticker did not send the second window")
+	}
+}
+
+func TestTicker_SkipsWindowsThatCloseWhileWaiting(t *testing.T) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFunc()
+	s, err := ParseSchedule("0 10 * * * for 1h", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %s", err)
+	}
+	clk := clock.NewFake(time.Date(2022, time.January, 7, 9, 0, 0, 0, time.UTC))
+	ticker := NewTicker([]Schedule{s}, clk)
+	defer ticker.Stop()
+
+	if err := clk.WaitForTimers(ctx, 1); err != nil {
+		t.Fatalf("ticker did not wait for the first window: %s", err)
+	}
+	// The first window opens and closes while the receiver is busy, e.g. with a run that overran.
+	clk.Set(time.Date(2022, time.January, 7, 10, 0, 0, 0, time.UTC))
+	if err := clk.WaitForTimers(ctx, 1); err != nil {
+		t.Fatalf("ticker did not wait for the first window to close: %s", err)
+	}
+	clk.Set(time.Date(2022, time.January, 7, 11, 0, 0, 0, time.UTC))
+	if err := clk.WaitForTimers(ctx, 1); err != nil {
+		t.Fatalf("ticker did not wait for the second window: %s", err)
+	}
+	clk.Set(time.Date(2022, time.January, 8, 10, 0, 0, 0, time.UTC))
+	select {
+	case got := <-ticker.C:
+		if want := time.Date(2022, time.January, 8, 10, 0, 0, 0, time.UTC); !got.Start.Equal(want) {
+			t.Errorf("window start actual %s != expected %s", got.Start, want)
+		}
+	case <-ctx.Done():
+		t.Fatalf("ticker did not send the second window")
+	}
+}
@@ -3,11 +3,13 @@ package periodic
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
 	hconfig "github.com/jamespfennell/hoard/config"
 	"github.com/jamespfennell/subwaydata.nyc/etl"
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
 	"github.com/jamespfennell/subwaydata.nyc/etl/config"
 	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
 )
@@ -41,23 +43,91 @@ func NewInterval(s string) (Interval, error) {
 	}, nil
 }
 
-func Run(ctx context.Context, ec *config.Config, hc *hconfig.Config, sc *storage.Client, intervals []Interval) {
-	var starts []time.Duration
-	startToTimeout := map[time.Duration]time.Duration{}
-	for _, interval := range intervals {
-		starts = append(starts, interval.Start)
-		startToTimeout[interval.Start] = interval.End - interval.Start
+// Duration returns the length of the interval.
+// Intervals whose end is not after their start run past midnight.
+func (i Interval) Duration() time.Duration {
+	d := i.End - i.Start
+	if d <= 0 {
+		d += 24 * time.Hour
 	}
-	ticker := NewTicker(starts, ec.Timezone.AsLoc())
+	return d
+}
+
+// Run runs the backlog in each window of the schedules, and whenever a run is triggered using the controller.
+//
+// The backlog is stopped at the end of the window. Days that are running finish their current stage
+// and are then abandoned, along with days that were not started, until the next window.
+// A window that opens while scheduled runs are paused is run when they are resumed, if it is still open.
+// Manually triggered runs are stopped at the end of the current or next window.
+// If the controller is nil, a controller that is not otherwise used is created.
+func Run(ctx context.Context, ec *config.Config, hc *hconfig.Config, sc *storage.Client, schedules []Schedule, c *Controller) {
+	run(ctx, clock.Real(), ec, hc, sc, schedules, c)
+}
+
+func run(ctx context.Context, clk clock.Clock, ec *config.Config, hc *hconfig.Config, sc *storage.Client, schedules []Schedule, c *Controller) {
+	if c == nil {
+		c = NewController()
+	}
+	ticker := NewTicker(schedules, clk)
 	defer ticker.Stop()
+	c.setTicker(ticker)
 	for {
 		select {
-		case start := <-ticker.C:
-			//ctx, cancelFunc := context.WithTimeout(ctx, startToTimeout[start])
-			fmt.Println("Running backlog for time", start)
-			etl.Backlog(ctx, ec, hc, sc, etl.BacklogOptions{})
+		case window := <-ticker.C:
+			if c.skipWindow(window) {
+				log.Printf("Skipping run scheduled for %s: scheduled runs are paused", window.Start)
+				continue
+			}
+			log.Printf("Running backlog for the window starting at %s; it will be stopped at %s", window.Start, window.End)
+			runBacklog(ctx, clk, c, triggerSchedule, window.End, ec, hc, sc)
+		case <-c.resumed:
+			window, ok := c.takePausedWindow()
+			if !ok {
+				continue
+			}
+			if !clk.Now().Before(window.End) {
+				log.Printf("Skipping run scheduled for %s: its window closed at %s while paused", window.Start, window.End)
+				continue
+			}
+			log.Printf("Running backlog for the window starting at %s, which opened while paused; it will be stopped at %s",
+				window.Start, window.End)
+			runBacklog(ctx, clk, c, triggerSchedule, window.End, ec, hc, sc)
+		case <-c.trigger:
+			// The window that the ticker will send next is either open or the next to open.
+			var deadline time.Time
+			if window, ok := ticker.Next(); ok {
+				deadline = window.End
+			}
+			if deadline.IsZero() {
+				log.Printf("Running backlog on demand; there are no more scheduled windows, so it will not be stopped")
+			} else {
+				log.Printf("Running backlog on demand; it will be stopped at %s", deadline)
+			}
+			runBacklog(ctx, clk, c, triggerManual, deadline, ec, hc, sc)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// runBacklog runs the backlog until it finishes or the deadline passes. A zero deadline means no deadline.
+func runBacklog(ctx context.Context, clk clock.Clock, c *Controller, trigger string, deadline time.Time, ec *config.Config, hc *hconfig.Config, sc *storage.Client) {
+	if !deadline.IsZero() {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = clock.WithDeadline(ctx, clk, deadline)
+		defer cancelFunc()
+	}
+	c.startRun(trigger, clk.Now(), deadline)
+	result, err := etl.Backlog(ctx, ec, hc, sc, etl.BacklogOptions{
+		RunOptions: etl.RunOptions{Clock: clk, Progress: c.progress},
+	})
+	c.finishRun(clk.Now(), result, err)
+	if err != nil {
+		log.Printf("Backlog finished with errors: %s", err)
+	}
+	if result == nil {
+		return
+	}
+	log.Printf("Backlog finished: %d day(s) succeeded, %d failed, %d deferred to the next window",
+		len(result.Succeeded), len(result.Failed), len(result.Deferred))
+}
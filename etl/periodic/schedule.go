@@ -0,0 +1,96 @@
+package periodic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a period of time in which the backlog runs.
+// The backlog is started at Start, or as soon as possible afterwards, and stopped at End.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Schedule is a recurring sequence of non-overlapping windows.
+type Schedule interface {
+	// Next returns the first window that ends after t. The window may have started before t.
+	// It returns false if there are no more windows.
+	Next(t time.Time) (Window, bool)
+}
+
+// ParseSchedule parses a schedule, which is either an interval of the form HH:MM:SS-HH:MM:SS or a cron
+// expression optionally followed by "for DURATION", e.g. "0 2 * * MON-FRI for 4h".
+//
+// Both are evaluated in loc, unless the cron expression specifies a timezone. The window of a cron
+// expression ends after the duration, or, if no duration is given or the next scheduled time comes first,
+// at the next scheduled time. Windows are at most 24 hours long.
+func ParseSchedule(s string, loc *time.Location) (Schedule, error) {
+	if interval, err := NewInterval(s); err == nil {
+		return &IntervalSchedule{Interval: interval, Loc: loc}, nil
+	}
+	expr, durationStr, hasDuration := strings.Cut(s, " for ")
+	c, err := ParseCronSchedule(expr, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither an interval (HH:MM:SS-HH:MM:SS) nor a cron expression: %w", s, err)
+	}
+	maxDuration := maxWindowDuration
+	if hasDuration {
+		maxDuration, err = time.ParseDuration(strings.TrimSpace(durationStr))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse duration in %q: %w", s, err)
+		}
+		if maxDuration <= 0 || maxDuration > maxWindowDuration {
+			return nil, fmt.Errorf("duration in %q must be positive and at most %s", s, maxWindowDuration)
+		}
+	}
+	return &cronWindows{schedule: c, maxDuration: maxDuration}, nil
+}
+
+const maxWindowDuration = 24 * time.Hour
+
+// IntervalSchedule runs the backlog in the same interval of wall clock time every day.
+type IntervalSchedule struct {
+	Interval Interval
+	Loc      *time.Location
+}
+
+func (s *IntervalSchedule) Next(t time.Time) (Window, bool) {
+	w := wallClock(t, s.Loc)
+	midnight := time.Date(w.Year(), w.Month(), w.Day(), 0, 0, 0, 0, time.UTC)
+	// An interval that spans midnight may have started the day before.
+	for day := -1; ; day++ {
+		start := midnight.AddDate(0, 0, day).Add(s.Interval.Start)
+		window := Window{
+			Start: resolveWallClock(start, s.Loc),
+			End:   resolveWallClock(start.Add(s.Interval.Duration()), s.Loc),
+		}
+		if window.End.After(t) {
+			return window, true
+		}
+	}
+}
+
+// cronWindows runs the backlog at the times given by a cron schedule.
+type cronWindows struct {
+	schedule    *CronSchedule
+	maxDuration time.Duration
+}
+
+func (c *cronWindows) Next(t time.Time) (Window, bool) {
+	// Find the most recent scheduled time whose window may still be open, and work forward from there.
+	start := c.schedule.Next(t.Add(-c.maxDuration))
+	for !start.IsZero() {
+		next := c.schedule.Next(start)
+		end := start.Add(c.maxDuration)
+		if !next.IsZero() && next.Before(end) {
+			end = next
+		}
+		if end.After(t) {
+			return Window{Start: start, End: end}, true
+		}
+		start = next
+	}
+	return Window{}, false
+}
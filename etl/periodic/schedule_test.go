@@ -0,0 +1,97 @@
+package periodic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	utc := time.UTC
+	now := time.Date(2022, time.January, 7, 10, 17, 0, 0, utc)
+	for _, tc := range []struct {
+		schedule string
+		t        time.Time
+		want     Window
+	}{
+		{
+			"02:00:00-06:00:00",
+			now,
+			Window{time.Date(2022, time.January, 8, 2, 0, 0, 0, utc), time.Date(2022, time.January, 8, 6, 0, 0, 0, utc)},
+		},
+		{
+			// The window is open, so it is returned for catch-up.
+			"10:00:00-11:00:00",
+			now,
+			Window{time.Date(2022, time.January, 7, 10, 0, 0, 0, utc), time.Date(2022, time.January, 7, 11, 0, 0, 0, utc)},
+		},
+		{
+			// The interval spans midnight and started the day before.
+			"22:00:00-11:00:00",
+			now,
+			Window{time.Date(2022, time.January, 6, 22, 0, 0, 0, utc), time.Date(2022, time.January, 7, 11, 0, 0, 0, utc)},
+		},
+		{
+			"0 * * * *",
+			now,
+			Window{time.Date(2022, time.January, 7, 10, 0, 0, 0, utc), time.Date(2022, time.January, 7, 11, 0, 0, 0, utc)},
+		},
+		{
+			"0 10 * * * for 10m",
+			now,
+			Window{time.Date(2022, time.January, 8, 10, 0, 0, 0, utc), time.Date(2022, time.January, 8, 10, 10, 0, 0, utc)},
+		},
+		{
+			"0 10 * * * for 30m",
+			now,
+			Window{time.Date(2022, time.January, 7, 10, 0, 0, 0, utc), time.Date(2022, time.January, 7, 10, 30, 0, 0, utc)},
+		},
+		{
+			// The window is cut short by the next scheduled time.
+			"*/5 * * * * for 1h",
+			now,
+			Window{time.Date(2022, time.January, 7, 10, 15, 0, 0, utc), time.Date(2022, time.January, 7, 10, 20, 0, 0, utc)},
+		},
+	} {
+		t.Run(tc.schedule, func(t *testing.T) {
+			s, err := ParseSchedule(tc.schedule, utc)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) failed: %s", tc.schedule, err)
+			}
+			got, ok := s.Next(tc.t)
+			if !ok || !got.Start.Equal(tc.want.Start) || !got.End.Equal(tc.want.End) {
+				t.Errorf("Next(%s) actual (%v, %t) != expected %v", tc.t, got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Errors(t *testing.T) {
+	for _, s := range []string{
+		"02:00:00-26:00:00",
+		"0 2 * * *  for",
+		"0 2 * * * for 25h",
+		"0 2 * * * for -1h",
+	} {
+		if _, err := ParseSchedule(s, time.UTC); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, expected error", s)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	utc := time.UTC
+	now := time.Date(2022, time.January, 7, 10, 17, 0, 0, utc)
+	var schedules []Schedule
+	for _, s := range []string{"0 2 * * MON-FRI for 4h", "30 * * * * for 10m"} {
+		schedule, err := ParseSchedule(s, utc)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q) failed: %s", s, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	got, ok := nextWindow(schedules, now)
+	want := Window{time.Date(2022, time.January, 7, 10, 30, 0, 0, utc), time.Date(2022, time.January, 7, 10, 40, 0, 0, utc)}
+	if !ok || got != want {
+		t.Errorf("nextWindow actual (%v, %t) != expected %v", got, ok, want)
+	}
+}
@@ -3,59 +3,109 @@ package periodic
 import (
 	"context"
 	"log"
+	"sync"
 	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
 )
 
+// Ticker sends the windows of a set of schedules on C as they open.
+//
+// If a window opens while the receiver is busy, or opened before the ticker was created, it is sent as
+// soon as possible provided it has not yet closed. This catches up on runs that were missed because the
+// process was down or a previous run overran. Windows that close before they can be sent, including while
+// the ticker is waiting for the receiver, are skipped.
 type Ticker struct {
-	C        <-chan time.Duration
+	C        <-chan Window
 	stopFunc context.CancelFunc
+
+	m       sync.Mutex
+	next    Window
+	hasNext bool
 }
 
-func NewTicker(starts []time.Duration, loc *time.Location) *Ticker {
-	c := make(chan time.Duration)
+// NewTicker returns a ticker for the schedules that measures time using the clock.
+func NewTicker(schedules []Schedule, clk clock.Clock) *Ticker {
+	c := make(chan Window)
 	ctx, cancelFunc := context.WithCancel(context.Background())
+	t := &Ticker{
+		C:        c,
+		stopFunc: cancelFunc,
+	}
 
 	go func() {
-		nextStart := 0
-		now := time.Now().In(loc)
-		y, m, d := now.Date()
-		midnight := time.Date(y, m, d, 0, 0, 0, 0, loc)
-
+		// Windows that end before this time have already been sent.
+		var after time.Time
 		for {
-			now := time.Now().In(loc)
-			absStart := midnight.Add(starts[nextStart])
-			pauseTime := absStart.Sub(now)
-			if pauseTime >= 0 {
+			now := clk.Now()
+			if now.Before(after) {
+				now = after
+			}
+			window, ok := nextWindow(schedules, now)
+			t.setNext(window, ok)
+			if !ok {
+				log.Printf("No more scheduled runs")
+				return
+			}
+			if pauseTime := clock.Until(clk, window.Start); pauseTime > 0 {
 				log.Printf("pausing for %s\n", pauseTime)
-				pauseTicker := time.NewTicker(pauseTime)
+				pauseTimer := clk.NewTimer(pauseTime)
 				select {
 				case <-ctx.Done():
-					pauseTicker.Stop()
+					pauseTimer.Stop()
 					return
-				case <-pauseTicker.C:
-					pauseTicker.Stop()
+				case <-pauseTimer.C():
 				}
-
+			}
+			if !clk.Now().Before(window.End) {
+				log.Printf("Skipping run scheduled for %s: its window closed at %s", window.Start, window.End)
+			} else {
+				closeTimer := clk.NewTimer(clock.Until(clk, window.End))
 				select {
 				case <-ctx.Done():
+					closeTimer.Stop()
 					return
-				case c <- starts[nextStart]:
+				case c <- window:
+					closeTimer.Stop()
+				case <-closeTimer.C():
+					log.Printf("Skipping run scheduled for %s: its window closed at %s while a previous run was in progress",
+						window.Start, window.End)
 				}
 			}
-
-			nextStart += 1
-			if nextStart == len(starts) {
-				nextStart = 0
-				tomorrowAfternoon := midnight.Add(32 * time.Hour)
-				y, m, d := tomorrowAfternoon.Date()
-				midnight = time.Date(y, m, d, 0, 0, 0, 0, loc)
-			}
+			after = window.End
 		}
 	}()
-	return &Ticker{
-		C:        c,
-		stopFunc: cancelFunc,
+	return t
+}
+
+// Next returns the window that will be sent next. It returns false if there are no more windows.
+func (t *Ticker) Next() (Window, bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.next, t.hasNext
+}
+
+func (t *Ticker) setNext(window Window, ok bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.next, t.hasNext = window, ok
+}
+
+// nextWindow returns the window that starts first out of the windows of the schedules that end after t.
+func nextWindow(schedules []Schedule, t time.Time) (Window, bool) {
+	var result Window
+	var found bool
+	for _, schedule := range schedules {
+		window, ok := schedule.Next(t)
+		if !ok {
+			continue
+		}
+		if !found || window.Start.Before(result.Start) {
+			result = window
+			found = true
+		}
 	}
+	return result, found
 }
 
 func (t *Ticker) Stop() {
@@ -1,61 +1,121 @@
 package etl
 
 import (
-	"archive/tar"
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
-	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/jamespfennell/gtfs/journal"
 	"github.com/jamespfennell/hoard"
 	hconfig "github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
 	"github.com/jamespfennell/subwaydata.nyc/etl/config"
 	"github.com/jamespfennell/subwaydata.nyc/etl/export"
+	"github.com/jamespfennell/subwaydata.nyc/etl/monitoring"
+	"github.com/jamespfennell/subwaydata.nyc/etl/quality"
 	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
 	"github.com/jamespfennell/subwaydata.nyc/metadata"
-	"github.com/jamespfennell/xz"
 )
 
-const softwareVersion = 4
+// Version of the pipeline that is recorded for every processed day. The backlog reprocesses days that were
+// processed by an earlier version, so the version is increased whenever the artifacts change.
+//
+// Version 5 added the Parquet and SQLite artifacts and the data quality report in the CSV archive.
+const softwareVersion = 5
+
+// Software versions that first produced each artifact. Artifacts that are not listed are produced by every version.
+var artifactSoftwareVersions = map[string]int{
+	"parquet_trips":      5,
+	"parquet_stop_times": 5,
+	"sqlite":             5,
+}
+
+// Names of the stages of the pipeline, as recorded in the working directory and in failure records.
+const (
+	stageDownload  = "download"
+	stageJournal   = "journal"
+	stageQuality   = "quality"
+	stageArtifacts = "artifacts"
+	stageUpload    = "upload"
+	stageMetadata  = "metadata"
+)
 
 type BacklogOptions struct {
+	RunOptions
 	Limit       *int
 	DryRun      bool
 	Concurrency int
 }
 
+// BacklogResult describes the outcome of running the backlog.
+type BacklogResult struct {
+	Succeeded []metadata.Day
+	Failed    []metadata.Day
+
+	// Days that were not started, or were interrupted, because the context was done.
+	// They remain in the backlog for the next run.
+	Deferred []metadata.Day
+}
+
+func (r *BacklogResult) add(list *[]metadata.Day, day metadata.Day, m *sync.Mutex) {
+	m.Lock()
+	defer m.Unlock()
+	*list = append(*list, day)
+}
+
 // Backlog runs the ETL pipeline for all days in the backlog.
-func Backlog(ctx context.Context, ec *config.Config, hc *hconfig.Config, sc *storage.Client, opts BacklogOptions) error {
-	now := time.Now().In(ec.Timezone.AsLoc()).Add(-29 * time.Hour).Format("2006-01-02")
-	endDay, _ := metadata.ParseDay(now)
+//
+// When the context is done no new days are started, and days that are running stop at the end of their
+// current stage without updating the metadata. These days are reported as deferred rather than failed.
+// Days that have started uploading their artifacts finish the upload and the metadata update instead.
+// Stages that don't finish within a grace period of the context being done are aborted.
+func Backlog(ctx context.Context, ec *config.Config, hc *hconfig.Config, sc *storage.Client, opts BacklogOptions) (*BacklogResult, error) {
+	clk := clock.OrReal(opts.Clock)
+	endDay := backlogEndDay(clk.Now(), ec.Timezone.AsLoc())
 
 	m, err := sc.GetMetadata(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to obtain metadata: %w", err)
+		return nil, fmt.Errorf("failed to obtain metadata: %w", err)
 	}
 
-	pendingDays := config.CalculatePendingDays(ec.Feeds, m.ProcessedDays, endDay, softwareVersion)
+	result := &BacklogResult{}
+	pendingDays := skipFailedDays(config.CalculatePendingDays(ec.Feeds, m.ProcessedDays, endDay, softwareVersion), m, ec, clk.Now())
+	monitoring.SetBacklogSize(len(pendingDays))
 	if len(pendingDays) == 0 {
 		log.Println("No days in the backlog")
-		return nil
+		return result, nil
 	}
 	log.Printf("%d days in the backlog:\n", len(pendingDays))
 	l := newLimiter(opts.Concurrency)
+	var resultM sync.Mutex
+	months := map[string]bool{}
+	numDays := 0
 	for i, pendingDay := range pendingDays {
 		pendingDay := pendingDay
 		if opts.Limit != nil && *opts.Limit <= i {
 			fmt.Println("Reached limit, ending...")
 			break
 		}
+		if !opts.DryRun {
+			months[pendingDay.Day.MonthString()] = true
+		}
 		l.run(func() error {
+			if ctx.Err() != nil {
+				result.add(&result.Deferred, pendingDay.Day, &resultM)
+				return nil
+			}
 			log.Printf("Processing backlog for %s\n", pendingDay.Day)
 			if opts.DryRun {
 				log.Printf("Skipping because in dry-run mode")
@@ -68,167 +128,477 @@ func Backlog(ctx context.Context, ec *config.Config, hc *hconfig.Config, sc *sto
 				ec,
 				hc,
 				sc,
+				opts.RunOptions,
 			)
+			// If the context is done the run was interrupted rather than failing, so no failure is recorded.
+			if err != nil && ctx.Err() != nil {
+				log.Printf("%s: interrupted: %s", pendingDay.Day, err)
+				result.add(&result.Deferred, pendingDay.Day, &resultM)
+				return nil
+			}
 			if err != nil {
 				log.Printf("%s: failed: %s", pendingDay.Day, err)
-			} else {
-				log.Printf("%s: success", pendingDay.Day)
+				result.add(&result.Failed, pendingDay.Day, &resultM)
+				if recordErr := recordFailedDay(ctx, pendingDay.Day, pendingDay.FeedIDs, err, clk.Now(), sc); recordErr != nil {
+					log.Printf("%s: failed to record failure: %s", pendingDay.Day, recordErr)
+				}
+				return fmt.Errorf("%s: %w", pendingDay.Day, err)
 			}
-			return err
+			log.Printf("%s: success", pendingDay.Day)
+			result.add(&result.Succeeded, pendingDay.Day, &resultM)
+			return nil
+		})
+		numDays++
+	}
+	failedErr := summarizeFailedDays(numDays, l.wait())
+	if !opts.DryRun {
+		monitoring.SetBacklogSize(len(pendingDays) - len(result.Succeeded))
+	}
+	for _, days := range [][]metadata.Day{result.Succeeded, result.Failed, result.Deferred} {
+		sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	}
+	if len(result.Deferred) > 0 {
+		log.Printf("%d day(s) deferred to the next run: %s", len(result.Deferred), result.Deferred)
+	}
+	// Days that completed before the context was done are in the metadata, so the checksums are written
+	// even if the context is done.
+	return result, errors.Join(failedErr, writeSha256Sums(context.WithoutCancel(ctx), months, sc))
+}
+
+// Maximum time a run may continue after its context is done, to finish the stage that is running or, once it
+// has started uploading its artifacts, to finish the upload and the metadata update.
+const stageGracePeriod = 30 * time.Minute
+
+// retrieve retrieves data from Hoard. It is a variable so that tests can replace it.
+var retrieve = hoard.Retrieve
+
+// Data for a day is retrieved from Hoard until four hours after the day ends, and Hoard takes some time to
+// archive data, so a day is only processed once five hours have passed since it ended.
+const backlogDelay = 5 * time.Hour
+
+// backlogEndDay returns the last day that is ready to be processed at the provided time.
+func backlogEndDay(now time.Time, loc *time.Location) metadata.Day {
+	// The day containing now-backlogDelay has not ended more than backlogDelay ago, but the day before it has.
+	t := now.Add(-backlogDelay).In(loc)
+	d := time.Date(t.Year(), t.Month(), t.Day()-1, 0, 0, 0, 0, time.UTC)
+	return metadata.NewDay(d.Year(), d.Month(), d.Day())
+}
+
+// skipFailedDays removes days that recently failed, or that are quarantined, from the pending days.
+func skipFailedDays(pendingDays []config.PendingDay, m *metadata.Metadata, ec *config.Config, now time.Time) []config.PendingDay {
+	var result []config.PendingDay
+	for _, pendingDay := range pendingDays {
+		f := m.FailedDay(pendingDay.Day)
+		if f == nil {
+			result = append(result, pendingDay)
+			continue
+		}
+		retryTime, ok := failedDayRetryTime(f, ec)
+		switch {
+		case !ok:
+			log.Printf("%s: skipping: quarantined after %d failed attempts; clear the failure to retry", pendingDay.Day, f.Attempts)
+		case now.Before(retryTime):
+			log.Printf("%s: skipping: failed %d time(s), will retry after %s", pendingDay.Day, f.Attempts, retryTime.Format(time.RFC3339))
+		default:
+			result = append(result, pendingDay)
+		}
+	}
+	return result
+}
+
+type RunDaysOptions struct {
+	RunOptions
+	// IDs of the feeds to process. If empty, the feeds active on each day according to the config are used.
+	FeedIDs     []string
+	Concurrency int
+}
+
+// RunDays runs the ETL pipeline for each of the provided days.
+func RunDays(ctx context.Context, days []metadata.Day, ec *config.Config, hc *hconfig.Config, sc *storage.Client, opts RunDaysOptions) error {
+	l := newLimiter(opts.Concurrency)
+	months := map[string]bool{}
+	for _, day := range days {
+		day := day
+		months[day.MonthString()] = true
+		feedIDs := opts.FeedIDs
+		if len(feedIDs) == 0 {
+			feedIDs = config.FeedIDsForDay(ec.Feeds, day)
+		}
+		l.run(func() error {
+			if len(feedIDs) == 0 {
+				err := fmt.Errorf("no feeds are configured for %s", day)
+				log.Printf("%s: failed: %s", day, err)
+				return fmt.Errorf("%s: %w", day, err)
+			}
+			err := Run(ctx, day, feedIDs, ec, hc, sc, opts.RunOptions)
+			if err != nil {
+				log.Printf("%s: failed: %s", day, err)
+				return fmt.Errorf("%s: %w", day, err)
+			}
+			log.Printf("%s: success", day)
+			return nil
 		})
 	}
-	return l.wait()
+	return errors.Join(summarizeFailedDays(len(days), l.wait()), writeSha256Sums(ctx, months, sc))
 }
 
-// DeleteDays deletes the specified days from the metadata.
-func DeleteDays(ctx context.Context, days []metadata.Day, dryRun bool, ec *config.Config, sc *storage.Client) error {
+type DeleteOptions struct {
+	// If true, the deletions are listed but not performed.
+	DryRun bool
+
+	// If true, the artifacts of the deleted days are also removed from object storage.
+	DeleteObjects bool
+}
+
+// DeleteDays deletes the specified days from the metadata and, optionally, their artifacts from object storage.
+//
+// The metadata is updated before the artifacts are removed so that it never references missing objects.
+func DeleteDays(ctx context.Context, days []metadata.Day, opts DeleteOptions, ec *config.Config, sc *storage.Client) error {
 	daysSet := map[metadata.Day]bool{}
 	for _, day := range days {
 		daysSet[day] = true
 	}
-	sc.UpdateMetadata(ctx, func(md *metadata.Metadata) bool {
-		var deleted []metadata.Day
+	var deleted []metadata.ProcessedDay
+	err := sc.UpdateMetadata(ctx, func(md *metadata.Metadata) bool {
+		deleted = nil
 		retainedDays := make([]metadata.ProcessedDay, 0, len(md.ProcessedDays))
 		for _, day := range md.ProcessedDays {
 			if daysSet[day.Day] {
-				deleted = append(deleted, day.Day)
+				deleted = append(deleted, day)
 				continue
 			}
 			retainedDays = append(retainedDays, day)
 		}
-		fmt.Printf("Will delete %d day(s): %s\n", len(deleted), deleted)
 		md.ProcessedDays = retainedDays
-		if dryRun {
-			fmt.Println("Skipping deletions because dry run mode is on.")
-			return false
-		}
-		fmt.Printf("Deleted %d day(s).\n", len(deleted))
-		return true
+		return !opts.DryRun && len(deleted) > 0
 	})
-	return nil
+	if err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	var deletedDays []metadata.Day
+	var objects []metadata.Artifact
+	var totalSize int64
+	for _, day := range deleted {
+		deletedDays = append(deletedDays, day.Day)
+		for _, name := range sortedKeys(day.Artifacts) {
+			objects = append(objects, day.Artifacts[name])
+			totalSize += day.Artifacts[name].Size
+		}
+	}
+	if opts.DryRun {
+		fmt.Printf("Will delete %d day(s): %s\n", len(deletedDays), deletedDays)
+		if opts.DeleteObjects {
+			fmt.Printf("Will delete %d object(s) totalling %d bytes:\n", len(objects), totalSize)
+			for _, object := range objects {
+				fmt.Printf("  %s (%d bytes)\n", object.Path, object.Size)
+			}
+		}
+		fmt.Println("Skipping deletions because dry run mode is on.")
+		return nil
+	}
+	fmt.Printf("Deleted %d day(s) from the metadata: %s\n", len(deletedDays), deletedDays)
+	months := map[string]bool{}
+	for _, day := range deletedDays {
+		months[day.MonthString()] = true
+	}
+	if err := writeSha256Sums(ctx, months, sc); err != nil {
+		return err
+	}
+	if !opts.DeleteObjects {
+		return nil
+	}
+	var errs []error
+	for _, object := range objects {
+		if err := sc.Delete(ctx, object.Path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %w", object.Path, err))
+			continue
+		}
+		fmt.Printf("Deleted %s (%d bytes)\n", object.Path, object.Size)
+	}
+	return errors.Join(errs...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exporters returns the exporters that produce the artifacts for a day.
+// To add a new artifact, add its exporter here.
+func exporters(day metadata.Day, feedIDs []string, ec *config.Config, qualityReportJson []byte) []export.Exporter {
+	filePrefix := fmt.Sprintf("%s%s_", ec.RemotePrefix, day)
+	return []export.Exporter{
+		&export.CsvExporter{
+			FilePrefix: filePrefix,
+			ExtraFiles: []export.File{{Name: "quality.json", Body: qualityReportJson}},
+		},
+		&export.GtfsrtExporter{
+			FeedIDs: feedIDs,
+			Start:   day.Start(ec.Timezone.AsLoc()),
+			End:     day.End(ec.Timezone.AsLoc()),
+		},
+		&export.ParquetExporter{Table: export.ParquetTrips},
+		&export.ParquetExporter{Table: export.ParquetStopTimes},
+		&export.SqliteExporter{},
+	}
 }
 
 // Run runs the ETL pipeline for the provided day.
-func Run(ctx context.Context, day metadata.Day, feedIDs []string, ec *config.Config, hc *hconfig.Config, sc *storage.Client) error {
+//
+// If a persistent working directory is used, the download, journal and artifact creation stages record their
+// results in it, and a subsequent run for the same day and feeds resumes after the last completed stage.
+func Run(ctx context.Context, day metadata.Day, feedIDs []string, ec *config.Config, hc *hconfig.Config, sc *storage.Client, opts RunOptions) (err error) {
 	log.Printf("starting %s", day)
-	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("subwaydatanyc_%s_*", day))
+	clk := clock.OrReal(opts.Clock)
+	w, err := openWorkDir(day, feedIDs, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary working directory: %w", err)
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	stage := stageDownload
+	stageStart := clk.Now()
+	opts.Progress.setStage(day, stage)
+	defer opts.Progress.finish(day)
+	// Stages run with a context that is only cancelled a grace period after ctx, so that the stage that is
+	// running when ctx is done can finish. Stages that overrun the grace period are aborted.
+	stageCtx, cancelStages := clock.WithGracePeriod(ctx, clk, stageGracePeriod)
+	defer cancelStages()
+	// Once the upload starts the run is committed and is no longer aborted between stages.
+	committed := false
+	// enterStage records the stage that is running and aborts the run if ctx is done and the run is not committed.
+	// Because ctx is only checked between stages, an aborted run never leaves partial results behind.
+	enterStage := func(s string) error {
+		if s != stage {
+			monitoring.RecordStageDuration(stage, clk.Now().Sub(stageStart))
+			stage, stageStart = s, clk.Now()
+		}
+		opts.Progress.setStage(day, stage)
+		if committed {
+			return nil
+		}
+		return ctx.Err()
 	}
-	defer os.RemoveAll(tmpDir)
+	defer func() {
+		monitoring.RecordStageDuration(stage, clk.Now().Sub(stageStart))
+		// Runs interrupted because the context is done are not failures.
+		if err != nil && ctx.Err() == nil {
+			monitoring.RecordFailure(stage)
+		}
+		if err != nil {
+			err = stageError{stage: stage, err: err}
+		}
+		w.close(err)
+	}()
+	rawDir := w.rawDir()
 
 	start := day.Start(ec.Timezone.AsLoc())
 	end := day.End(ec.Timezone.AsLoc())
 
 	// Stage one: download the data from Hoard
 	log.Printf("%s: stage 1 (download data)", day)
-	availableFeedIDs := map[string]bool{}
-	for _, feed := range hc.Feeds {
-		availableFeedIDs[feed.ID] = true
-	}
-	var feeds []hconfig.Feed
-	for _, feedID := range feedIDs {
-		if !availableFeedIDs[feedID] {
-			return fmt.Errorf("feed %q does not appear in the Hoard config", feedID)
-		}
-		feeds = append(feeds, hconfig.Feed{
-			ID: feedID,
+	if _, err := w.runStage(stageDownload, func() error {
+		return retry(stageCtx, clk, fmt.Sprintf("%s: download", day), retryPolicy(ec, stageDownload), func() error {
+			// Remove any data from a previous partial download.
+			if err := os.RemoveAll(rawDir); err != nil {
+				return err
+			}
+			if err := os.Mkdir(rawDir, 0755); err != nil {
+				return err
+			}
+			if err := download(feedIDs, start, end, hc, rawDir); err != nil {
+				return err
+			}
+			numBytes, err := dirSize(rawDir)
+			if err != nil {
+				return err
+			}
+			monitoring.RecordDownload(numBytes)
+			return nil
 		})
-	}
-	err = hoard.Retrieve(
-		&hconfig.Config{
-			Feeds:         feeds,
-			ObjectStorage: hc.ObjectStorage,
-		},
-		hoard.RetrieveOptions{
-			Path:            tmpDir,
-			KeepPacked:      false,
-			FlattenTimeDirs: true,
-			FlattenFeedDirs: false,
-			Start:           day.Start(ec.Timezone.AsLoc()).Add(-4 * time.Hour),
-			End:             day.End(ec.Timezone.AsLoc()).Add(4 * time.Hour),
-		},
-	)
-	if err != nil {
+	}); err != nil {
 		return err
 	}
 
 	// Stage two: run the journal code on each directory of downloaded data.
+	// If the working directory is persistent the journal is saved in it, so that it doesn't need to be rebuilt
+	// if a later stage fails.
+	if err := enterStage(stageJournal); err != nil {
+		return err
+	}
 	log.Printf("%s: stage 2 (journal)", day)
 	mergedJournal := journal.Journal{}
-	for _, feedID := range feedIDs {
-		source, err := journal.NewDirectoryGtfsrtSource(filepath.Join(tmpDir, feedID))
+	skipped, err := w.runStage(stageJournal, func() error {
+		for _, feedID := range feedIDs {
+			source, err := journal.NewDirectoryGtfsrtSource(filepath.Join(rawDir, feedID))
+			if err != nil {
+				return err
+			}
+			j := journal.BuildJournal(source, start, end)
+			mergedJournal.Trips = append(mergedJournal.Trips, j.Trips...)
+		}
+		if !w.persistent {
+			return nil
+		}
+		return w.writeJournal(&mergedJournal)
+	})
+	if err != nil {
+		return err
+	}
+	if skipped {
+		j, err := w.readJournal()
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to read journal: %w", err)
 		}
-		j := journal.BuildJournal(
-			source,
-			day.Start(ec.Timezone.AsLoc()),
-			day.End(ec.Timezone.AsLoc()),
-		)
-		mergedJournal.Trips = append(mergedJournal.Trips, j.Trips...)
+		mergedJournal = *j
 	}
 
-	// Stage three: export all of the trips.
-	log.Printf("%s: stage 3 (create csv)", day)
-	csvBytes, err := export.Export(&mergedJournal, fmt.Sprintf("%s%s_", ec.RemotePrefix, day))
-	if err != nil {
-		return fmt.Errorf("failed to export trips to CSV: %w", err)
+	// Stage three: build the data quality report.
+	if err := enterStage(stageQuality); err != nil {
+		return err
 	}
-
-	// Stage four: create the tar xz of GTFS files.
-	log.Printf("%s: stage 4 (create gtfsrt)", day)
-	gtfsrtBytes, err := createGtfsrtExport(start, end, tmpDir, feedIDs)
+	log.Printf("%s: stage 3 (quality report)", day)
+	coverage := map[string]quality.FeedCoverage{}
+	for _, feedID := range feedIDs {
+		snapshotTimes, err := readSnapshotTimes(filepath.Join(rawDir, feedID), start, end)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot times for feed %s: %w", feedID, err)
+		}
+		coverage[feedID] = quality.NewFeedCoverage(snapshotTimes, start, end, ec.CoverageGapThreshold.AsDuration())
+	}
+	qualityReport := quality.NewReport(&mergedJournal, coverage)
+	qualityReportJson, err := json.MarshalIndent(qualityReport, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create GTFS-RT export: %w", err)
+		return fmt.Errorf("failed to serialize quality report: %w", err)
 	}
 
-	// Stage five: upload data to object storage.
-	log.Printf("%s: stage 5 (upload)", day)
-	csvSha256, err := calculateSha256(csvBytes)
-	if err != nil {
-		return fmt.Errorf("failed to calculate SHA-256 hash of CSV upload: %w", err)
+	// Stage four: create the artifacts.
+	// Artifacts are written to the working directory rather than held in memory, as the GTFS-RT
+	// archive in particular can be many gigabytes.
+	exps := exporters(day, feedIDs, ec, qualityReportJson)
+	localArtifacts := make([]*localArtifact, len(exps))
+	for i, e := range exps {
+		if err := enterStage(stageArtifacts); err != nil {
+			return err
+		}
+		log.Printf("%s: stage 4 (create %s)", day, e.Name())
+		path := filepath.Join(w.artifactsDir(), fmt.Sprintf("%s.%s", e.Name(), e.Extension()))
+		skipped, err := w.runStage("artifact_"+e.Name(), func() error {
+			var err error
+			localArtifacts[i], err = createLocalArtifact(
+				path,
+				func(w io.Writer) error {
+					r, err := e.Export(stageCtx, &mergedJournal, rawDir)
+					if err != nil {
+						return err
+					}
+					if c, ok := r.(io.Closer); ok {
+						defer c.Close()
+					}
+					_, err = io.Copy(w, r)
+					return err
+				},
+			)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create %s artifact: %w", e.Name(), err)
+		}
+		if skipped {
+			localArtifacts[i], err = readLocalArtifact(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s artifact: %w", e.Name(), err)
+			}
+		}
 	}
-	target := fmt.Sprintf("%s/%s%s_%s_%s.tar.xz", day.MonthString(), ec.RemotePrefix, day, "csv", csvSha256)
-	if err := sc.Write(ctx, csvBytes, target); err != nil {
-		return fmt.Errorf("failed to copy csv bytes to object storage: %w", err)
+	// Stage five: upload the artifacts to object storage.
+	// Artifact paths contain the checksum and artifacts are reproducible, so if the current metadata already
+	// references an identical object at the target path the upload is skipped. Unreferenced objects are
+	// always rewritten, even if they exist, as garbage collection may be about to delete them.
+	//
+	// Once the upload starts the run is committed, so that an interrupted run finishes rather than stopping
+	// between the upload and the metadata update.
+	if err := enterStage(stageUpload); err != nil {
+		return err
 	}
-
-	gtfsrtSha256, err := calculateSha256(gtfsrtBytes)
-	if err != nil {
-		return fmt.Errorf("failed to calculate SHA-256 hash of GTFS-RT upload: %w", err)
+	committed = true
+	log.Printf("%s: stage 5 (upload)", day)
+	var referenced map[string]string
+	if err := retry(stageCtx, clk, fmt.Sprintf("%s: read metadata", day), retryPolicy(ec, stageUpload), func() error {
+		m, err := sc.GetMetadata(stageCtx)
+		if err != nil {
+			return err
+		}
+		referenced = referencedSha256s(m)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
 	}
-	gtfsrtTarget := fmt.Sprintf("%s/%s%s_%s_%s.tar.xz", day.MonthString(), ec.RemotePrefix, day, "gtfsrt", gtfsrtSha256)
-	if err := sc.Write(ctx, gtfsrtBytes, gtfsrtTarget); err != nil {
-		return fmt.Errorf("failed to copy gtfsrt to object storage: %w", err)
+	var numUploaded, numReused int
+	artifacts := map[string]metadata.Artifact{}
+	for i, e := range exps {
+		a := localArtifacts[i]
+		target := artifactPath(ec, day, e.Name(), a.checksum[:12], e.Extension())
+		var uploaded bool
+		err := retry(stageCtx, clk, fmt.Sprintf("%s: upload %s", day, e.Name()), retryPolicy(ec, stageUpload), func() error {
+			var err error
+			uploaded, err = a.uploadIfMissing(stageCtx, sc, target, referenced[target])
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to copy %s to object storage: %w", e.Name(), err)
+		}
+		if uploaded {
+			numUploaded++
+			monitoring.RecordUpload(a.size)
+		} else {
+			log.Printf("%s: %s artifact already exists at %s, skipping upload", day, e.Name(), target)
+			numReused++
+		}
+		artifacts[e.Name()] = metadata.Artifact{
+			Size:     a.size,
+			Path:     target,
+			Checksum: a.checksum[:12],
+			Sha256:   a.checksum,
+		}
 	}
 
+	log.Printf("%s: uploaded %d artifact(s), reused %d existing artifact(s)", day, numUploaded, numReused)
+
 	// Stage six: update the metadata.
+	if err := enterStage(stageMetadata); err != nil {
+		return err
+	}
 	log.Printf("%s: stage 6 (metadata update)", day)
 	newProcessedDay := metadata.ProcessedDay{
 		Day:             day,
 		Feeds:           feedIDs,
-		Created:         time.Now(),
+		Created:         clk.Now(),
 		SoftwareVersion: softwareVersion,
-		Csv: metadata.Artifact{
-			Size:     int64(len(csvBytes)),
-			Path:     target,
-			Checksum: csvSha256,
-		},
-		Gtfsrt: metadata.Artifact{
-			Size:     int64(len(gtfsrtBytes)),
-			Path:     gtfsrtTarget,
-			Checksum: gtfsrtSha256,
-		},
+		Artifacts:       artifacts,
+		Quality:         qualityReport.Summary(),
+		Coverage:        map[string][]metadata.Gap{},
+	}
+	for feedID, feedCoverage := range coverage {
+		newProcessedDay.Coverage[feedID] = feedCoverage.Gaps
 	}
 	if err := sc.UpdateMetadata(
-		ctx,
+		stageCtx,
 		func(m *metadata.Metadata) bool {
+			// The day succeeded, so any record of previous failures is obsolete.
+			clearedFailure := m.RemoveFailedDay(day)
 			for i := range m.ProcessedDays {
 				if m.ProcessedDays[i].Day == day {
 					if m.ProcessedDays[i].SoftwareVersion > softwareVersion {
 						log.Printf("Not updating Git metadata: existing data built with newer software")
-						return false
+						return clearedFailure
+					}
+					if unchangedExceptCreated(m.ProcessedDays[i], newProcessedDay) {
+						log.Printf("%s: not updating metadata: only the creation time would change", day)
+						return clearedFailure
 					}
 					m.ProcessedDays[i] = newProcessedDay
 					return true
@@ -240,95 +610,203 @@ func Run(ctx context.Context, day metadata.Day, feedIDs []string, ec *config.Con
 	); err != nil {
 		return fmt.Errorf("failed to update metadata: %w", err)
 	}
+	numStopTimes := 0
+	for _, trip := range mergedJournal.Trips {
+		numStopTimes += len(trip.StopTimes)
+	}
+	monitoring.RecordSuccess(clk.Now(), len(mergedJournal.Trips), numStopTimes)
 	return nil
 }
 
-func calculateSha256(b []byte) (string, error) {
-	h := sha256.New()
-	h.Write(b)
-	return fmt.Sprintf("%x", h.Sum(nil))[:12], nil
+// dirSize returns the total size of the files in the directory.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
 }
 
-//go:embed gtfsrt_readme.md
-var gtfsrtReadme []byte
-
-func createGtfsrtExport(start, end time.Time, sourceDir string, feedIDs []string) ([]byte, error) {
-	var gtfsrtTarXz bytes.Buffer
-	xw := xz.NewWriter(&gtfsrtTarXz)
-	tw := tar.NewWriter(xw)
-	now := time.Now()
-	if err := tw.WriteHeader(&tar.Header{
-		// We chose this file name so that it appears first in the archive file.
-		// The filename readme.md would appear below the nycsubway_*.gtfsrt data files.
-		Name:       "gtfsrt_readme.md",
-		Mode:       0600,
-		Size:       int64(len(gtfsrtReadme)),
-		ModTime:    now,
-		AccessTime: now,
-		ChangeTime: now,
-	}); err != nil {
-		return nil, err
+// download retrieves the data for the feeds in the interval [start, end] from Hoard into dir.
+// Data from four hours either side of the interval is also retrieved, as it is needed to build the journal.
+func download(feedIDs []string, start, end time.Time, hc *hconfig.Config, dir string) error {
+	availableFeedIDs := map[string]bool{}
+	for _, feed := range hc.Feeds {
+		availableFeedIDs[feed.ID] = true
+	}
+	var feeds []hconfig.Feed
+	for _, feedID := range feedIDs {
+		if !availableFeedIDs[feedID] {
+			return permanentError{fmt.Errorf("feed %q does not appear in the Hoard config", feedID)}
+		}
+		feeds = append(feeds, hconfig.Feed{
+			ID: feedID,
+		})
 	}
-	if _, err := tw.Write(gtfsrtReadme); err != nil {
+	return retrieve(
+		&hconfig.Config{
+			Feeds:         feeds,
+			ObjectStorage: hc.ObjectStorage,
+		},
+		hoard.RetrieveOptions{
+			Path:            dir,
+			KeepPacked:      false,
+			FlattenTimeDirs: true,
+			FlattenFeedDirs: false,
+			Start:           start.Add(-4 * time.Hour),
+			End:             end.Add(4 * time.Hour),
+		},
+	)
+}
+
+// unchangedExceptCreated returns true if the processed days differ at most in their creation time.
+// The days are compared in their serialized form because that is what is stored in the metadata.
+func unchangedExceptCreated(a, b metadata.ProcessedDay) bool {
+	a.Created, b.Created = time.Time{}, time.Time{}
+	aJson, aErr := json.Marshal(a)
+	bJson, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aJson, bJson)
+}
+
+// readSnapshotTimes returns the times of the GTFS-RT snapshots in dir in the interval [start, end].
+func readSnapshotTimes(dir string, start, end time.Time) ([]time.Time, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
 		return nil, err
 	}
-	for _, feedID := range feedIDs {
-		files, err := os.ReadDir(filepath.Join(sourceDir, feedID))
+	var times []time.Time
+	for _, file := range files {
+		info, err := file.Info()
 		if err != nil {
 			return nil, err
 		}
-		now := time.Now()
-		for i, file := range files {
-			if time.Since(now) > time.Second {
-				// TODO: debug logging
-				//	log.Printf("GTFS-RT export for feed %s: processed %d/%d files\n", feedID, i, len(files))
-				_ = i
-				now = time.Now()
-			}
-			info, err := file.Info()
-			if err != nil {
-				return nil, err
-			}
-			if info.ModTime().Before(start) || end.Before(info.ModTime()) {
-				continue
-			}
-			hdr := &tar.Header{
-				Name:       file.Name(),
-				Mode:       0600,
-				Size:       info.Size(),
-				ModTime:    info.ModTime(),
-				AccessTime: info.ModTime(),
-				ChangeTime: info.ModTime(),
-			}
-			if err := tw.WriteHeader(hdr); err != nil {
-				return nil, err
-			}
-			f, err := os.Open(filepath.Join(sourceDir, feedID, file.Name()))
-			if err != nil {
-				return nil, err
+		if info.ModTime().Before(start) || end.Before(info.ModTime()) {
+			continue
+		}
+		times = append(times, info.ModTime())
+	}
+	return times, nil
+}
 
-			}
-			if _, err := io.Copy(tw, f); err != nil {
-				_ = f.Close()
-				return nil, err
-			}
-			if err := f.Close(); err != nil {
-				return nil, err
+// localArtifact is an artifact that has been written to the local disk.
+type localArtifact struct {
+	path string
+	size int64
+	// Hex encoded SHA-256 checksum.
+	checksum string
+}
+
+// createLocalArtifact creates an artifact at the provided path using the provided write function.
+// The size and SHA-256 checksum are calculated as the artifact is written.
+func createLocalArtifact(path string, write func(w io.Writer) error) (*localArtifact, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	bw := bufio.NewWriter(f)
+	cw := &countingWriter{w: io.MultiWriter(bw, h)}
+	if err := write(cw); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &localArtifact{
+		path:     path,
+		size:     cw.n,
+		checksum: fmt.Sprintf("%x", h.Sum(nil)),
+	}, nil
+}
+
+// uploadIfMissing uploads the artifact unless the metadata references the remote path with the artifact's
+// SHA-256 checksum and the object exists. It returns true if the artifact was uploaded.
+//
+// referencedSha256 is the checksum the metadata records for the remote path, or empty if the path is not
+// referenced. Uploading over an unreferenced object refreshes its modification time, so that garbage
+// collection doesn't delete it before the new metadata referencing it is written.
+func (a *localArtifact) uploadIfMissing(ctx context.Context, sc *storage.Client, remotePath string, referencedSha256 string) (bool, error) {
+	if referencedSha256 == a.checksum {
+		info, err := sc.Stat(ctx, remotePath)
+		if err == nil && info.Size == a.size {
+			return false, nil
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return false, err
+		}
+	}
+	return true, a.upload(ctx, sc, remotePath)
+}
+
+// referencedSha256s returns the SHA-256 checksums of the artifacts in the metadata, keyed by path.
+// Artifacts recorded without a full checksum are omitted.
+func referencedSha256s(m *metadata.Metadata) map[string]string {
+	result := map[string]string{}
+	for _, processedDay := range m.ProcessedDays {
+		for _, a := range processedDay.Artifacts {
+			if a.Sha256 != "" {
+				result[a.Path] = a.Sha256
 			}
 		}
 	}
-	if err := tw.Close(); err != nil {
+	return result
+}
+
+// readLocalArtifact reads an artifact that was written to the local disk by a previous run.
+func readLocalArtifact(path string) (*localArtifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
 		return nil, err
 	}
-	if err := xw.Close(); err != nil {
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
 		return nil, err
 	}
-	return gtfsrtTarXz.Bytes(), nil
+	return &localArtifact{
+		path:     path,
+		size:     n,
+		checksum: fmt.Sprintf("%x", h.Sum(nil)),
+	}, nil
+}
+
+func (a *localArtifact) upload(ctx context.Context, sc *storage.Client, remotePath string) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return sc.Write(ctx, f, remotePath)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 type limiter struct {
 	c    chan struct{}
-	err  error
+	errs []error
 	errM sync.Mutex
 	wg   sync.WaitGroup
 }
@@ -354,14 +832,32 @@ func (l *limiter) run(f func() error) {
 		l.c <- struct{}{}
 		if err != nil {
 			l.errM.Lock()
-			l.err = err
+			l.errs = append(l.errs, err)
 			l.errM.Unlock()
 		}
 		l.wg.Done()
 	}()
 }
 
-func (l *limiter) wait() error {
+// wait waits for all functions to finish and returns the errors they returned.
+func (l *limiter) wait() []error {
 	l.wg.Wait()
-	return l.err
+	return l.errs
+}
+
+// summarizeFailedDays logs every day that failed and returns an error joining their errors.
+// The errors are expected to be prefixed with the day they relate to.
+func summarizeFailedDays(numDays int, errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	sortedErrs := append([]error(nil), errs...)
+	sort.Slice(sortedErrs, func(i, j int) bool {
+		return sortedErrs[i].Error() < sortedErrs[j].Error()
+	})
+	log.Printf("%d of %d day(s) failed:", len(sortedErrs), numDays)
+	for _, err := range sortedErrs {
+		log.Printf("  %s", err)
+	}
+	return errors.Join(sortedErrs...)
 }
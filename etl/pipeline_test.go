@@ -0,0 +1,394 @@
+package etl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/hoard"
+	hconfig "github.com/jamespfennell/hoard/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestDeleteDays(t *testing.T) {
+	jan1 := metadata.NewDay(2022, time.January, 1)
+	jan2 := metadata.NewDay(2022, time.January, 2)
+	for _, tc := range []struct {
+		name        string
+		opts        DeleteOptions
+		wantDays    []metadata.Day
+		wantObjects []string
+	}{
+		{
+			name:        "dry run",
+			opts:        DeleteOptions{DryRun: true, DeleteObjects: true},
+			wantDays:    []metadata.Day{jan2, jan1},
+			wantObjects: []string{"2022-01/a_2022-01-01_csv.tar.xz", "2022-01/a_2022-01-02_csv.tar.xz"},
+		},
+		{
+			name:        "metadata only",
+			opts:        DeleteOptions{},
+			wantDays:    []metadata.Day{jan2},
+			wantObjects: []string{"2022-01/a_2022-01-01_csv.tar.xz", "2022-01/a_2022-01-02_csv.tar.xz"},
+		},
+		{
+			name:        "with objects",
+			opts:        DeleteOptions{DeleteObjects: true},
+			wantDays:    []metadata.Day{jan2},
+			wantObjects: []string{"2022-01/a_2022-01-02_csv.tar.xz"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			ec, sc := newTestClient(t)
+			for _, day := range []metadata.Day{jan1, jan2} {
+				path := "2022-01/a_" + day.String() + "_csv.tar.xz"
+				if err := sc.Write(ctx, strings.NewReader("data"), path); err != nil {
+					t.Fatalf("failed to write object: %s", err)
+				}
+				day := day
+				if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+					m.ProcessedDays = append(m.ProcessedDays, metadata.ProcessedDay{
+						Day: day,
+						Artifacts: map[string]metadata.Artifact{
+							metadata.ArtifactCsv: {Size: 4, Path: path},
+						},
+					})
+					return true
+				}); err != nil {
+					t.Fatalf("failed to write metadata: %s", err)
+				}
+			}
+
+			if err := DeleteDays(ctx, []metadata.Day{jan1}, tc.opts, ec, sc); err != nil {
+				t.Fatalf("DeleteDays failed: %s", err)
+			}
+
+			m, err := sc.GetMetadata(ctx)
+			if err != nil {
+				t.Fatalf("failed to read metadata: %s", err)
+			}
+			var days []metadata.Day
+			for _, processedDay := range m.ProcessedDays {
+				days = append(days, processedDay.Day)
+			}
+			if !reflect.DeepEqual(days, tc.wantDays) {
+				t.Errorf("days actual %v != expected %v", days, tc.wantDays)
+			}
+			var objects []string
+			for _, day := range []metadata.Day{jan1, jan2} {
+				path := "2022-01/a_" + day.String() + "_csv.tar.xz"
+				if _, err := sc.Stat(ctx, path); errors.Is(err, storage.ErrNotFound) {
+					continue
+				} else if err != nil {
+					t.Fatalf("failed to stat object: %s", err)
+				}
+				objects = append(objects, path)
+			}
+			if !reflect.DeepEqual(objects, tc.wantObjects) {
+				t.Errorf("objects actual %v != expected %v", objects, tc.wantObjects)
+			}
+		})
+	}
+}
+
+func TestUploadIfMissing(t *testing.T) {
+	ctx := context.Background()
+	_, sc := newTestClient(t)
+	a, err := createLocalArtifact(filepath.Join(t.TempDir(), "artifact"), func(w io.Writer) error {
+		_, err := w.Write([]byte("data"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to create artifact: %s", err)
+	}
+	for i, c := range []struct {
+		referencedSha256 string
+		want             bool
+	}{
+		// The object doesn't exist.
+		{a.checksum, true},
+		// The object exists but isn't referenced by the metadata, so it may be garbage collected.
+		{"", true},
+		// The metadata references a different object at the path.
+		{strings.Repeat("0", 64), true},
+		{a.checksum, false},
+	} {
+		uploaded, err := a.uploadIfMissing(ctx, sc, "2022-01/artifact", c.referencedSha256)
+		if err != nil {
+			t.Fatalf("uploadIfMissing failed: %s", err)
+		}
+		if uploaded != c.want {
+			t.Errorf("upload %d: uploaded actual %t != expected %t", i, uploaded, c.want)
+		}
+	}
+}
+
+func TestUnchangedExceptCreated(t *testing.T) {
+	day := metadata.ProcessedDay{
+		Day:             metadata.NewDay(2022, time.January, 1),
+		Feeds:           []string{"feedID"},
+		Created:         time.Now(),
+		SoftwareVersion: softwareVersion,
+		Artifacts:       map[string]metadata.Artifact{metadata.ArtifactCsv: {Path: "a"}},
+		Coverage:        map[string][]metadata.Gap{},
+	}
+	// The existing day has been through a serialization round trip.
+	var existing metadata.ProcessedDay
+	b, _ := json.Marshal(day)
+	if err := json.Unmarshal(b, &existing); err != nil {
+		t.Fatalf("failed to unmarshal processed day: %s", err)
+	}
+	existing.Created = existing.Created.Add(-time.Hour)
+	if !unchangedExceptCreated(existing, day) {
+		t.Errorf("unchangedExceptCreated(%+v, %+v) = false, expected true", existing, day)
+	}
+	existing.SoftwareVersion--
+	if unchangedExceptCreated(existing, day) {
+		t.Errorf("unchangedExceptCreated(%+v, %+v) = true, expected false", existing, day)
+	}
+}
+
+func TestSummarizeFailedDays(t *testing.T) {
+	if err := summarizeFailedDays(2, nil); err != nil {
+		t.Errorf("summarizeFailedDays with no errors actual %v != expected nil", err)
+	}
+	errA := errors.New("2022-01-02: failed")
+	errB := errors.New("2022-01-01: failed")
+	err := summarizeFailedDays(3, []error{errA, errB})
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("summarizeFailedDays error %v does not include every failure", err)
+	}
+	if want := "2022-01-01: failed\n2022-01-02: failed"; err.Error() != want {
+		t.Errorf("summarizeFailedDays error actual %q != expected %q", err, want)
+	}
+}
+
+func TestBacklog_ContextDone(t *testing.T) {
+	ec, sc := newTestClient(t)
+	ec.Feeds = []config.Feed{{
+		Id:       "feedID",
+		FirstDay: metadata.NewDay(2022, time.January, 1),
+		LastDay:  ptr(metadata.NewDay(2022, time.January, 2)),
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := Backlog(ctx, ec, nil, sc, BacklogOptions{})
+	if err != nil {
+		t.Fatalf("Backlog failed: %s", err)
+	}
+	wantDeferred := []metadata.Day{metadata.NewDay(2022, time.January, 1), metadata.NewDay(2022, time.January, 2)}
+	if !reflect.DeepEqual(result.Deferred, wantDeferred) {
+		t.Errorf("deferred days actual %v != expected %v", result.Deferred, wantDeferred)
+	}
+	if len(result.Succeeded) != 0 || len(result.Failed) != 0 {
+		t.Errorf("days succeeded or failed after the context was done: %+v", result)
+	}
+	m, err := sc.GetMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	if len(m.FailedDays) != 0 {
+		t.Errorf("deferred days were recorded as failures: %v", m.FailedDays)
+	}
+}
+
+func TestBacklog_ContextDoneDuringStage(t *testing.T) {
+	day := metadata.NewDay(2022, time.January, 1)
+	for _, tc := range []struct {
+		name string
+		// Number of downloads and artifact uploads that fail, or -1 if they all fail.
+		failedDownloads int
+		failedUploads   int
+		// Backoff before retrying a failed download or upload.
+		backoff time.Duration
+		// Amount the clock is advanced by once the context is done.
+		advance       time.Duration
+		wantSucceeded bool
+		wantDownloads int
+	}{
+		{
+			// The download stage finishes, and the run is aborted before the next stage.
+			name:            "download finishes within the grace period",
+			failedDownloads: 1,
+			backoff:         time.Minute,
+			advance:         time.Minute,
+			wantDownloads:   2,
+		},
+		{
+			name:            "download does not finish within the grace period",
+			failedDownloads: -1,
+			backoff:         2 * stageGracePeriod,
+			advance:         stageGracePeriod,
+			wantDownloads:   1,
+		},
+		{
+			// The run is committed once the upload starts, so it finishes.
+			name:          "upload finishes within the grace period",
+			failedUploads: 1,
+			backoff:       time.Minute,
+			advance:       time.Minute,
+			wantSucceeded: true,
+			wantDownloads: 1,
+		},
+		{
+			name:          "upload does not finish within the grace period",
+			failedUploads: -1,
+			backoff:       2 * stageGracePeriod,
+			advance:       stageGracePeriod,
+			wantDownloads: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ec := newTestConfig(t)
+			ec.Feeds = []config.Feed{{Id: "feedID", FirstDay: day, LastDay: ptr(day)}}
+			policy := config.RetryPolicy{MaxAttempts: 3, InitialBackoff: config.Duration(tc.backoff)}
+			ec.RetryPolicies = map[string]config.RetryPolicy{stageDownload: policy, stageUpload: policy}
+			backend, err := storage.NewBackend(ec)
+			if err != nil {
+				t.Fatalf("failed to create backend: %s", err)
+			}
+			sc := storage.NewClientWithBackend(ec, &failingPutBackend{Backend: backend, substr: day.String() + "_", n: tc.failedUploads})
+			hc := &hconfig.Config{Feeds: []hconfig.Feed{{ID: "feedID"}}}
+			originalRetrieve := retrieve
+			t.Cleanup(func() { retrieve = originalRetrieve })
+			var numDownloads int
+			retrieve = func(_ *hconfig.Config, o hoard.RetrieveOptions) error {
+				numDownloads++
+				if tc.failedDownloads < 0 || numDownloads <= tc.failedDownloads {
+					return errors.New("hoard unavailable")
+				}
+				return os.Mkdir(filepath.Join(o.Path, "feedID"), 0755)
+			}
+			// Temporary working directories are created here.
+			tempDir := t.TempDir()
+			t.Setenv("TMPDIR", tempDir)
+			clk := clock.NewFake(time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			type backlogResult struct {
+				result *BacklogResult
+				err    error
+			}
+			c := make(chan backlogResult, 1)
+			go func() {
+				result, err := Backlog(ctx, ec, hc, sc, BacklogOptions{RunOptions: RunOptions{Clock: clk}})
+				c <- backlogResult{result, err}
+			}()
+			waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer waitCancel()
+			// Wait for the stage to start waiting to retry, then cancel the context and wait for the grace
+			// period timer.
+			if err := clk.WaitForTimers(waitCtx, 1); err != nil {
+				t.Fatalf("WaitForTimers failed: %s", err)
+			}
+			cancel()
+			if err := clk.WaitForTimers(waitCtx, 2); err != nil {
+				t.Fatalf("WaitForTimers failed: %s", err)
+			}
+			clk.Advance(tc.advance)
+			var r backlogResult
+			select {
+			case r = <-c:
+			case <-waitCtx.Done():
+				t.Fatalf("Backlog did not return")
+			}
+
+			if r.err != nil {
+				t.Fatalf("Backlog failed: %s", r.err)
+			}
+			if numDownloads != tc.wantDownloads {
+				t.Errorf("downloads actual %d != expected %d", numDownloads, tc.wantDownloads)
+			}
+			wantResult := &BacklogResult{Deferred: []metadata.Day{day}}
+			if tc.wantSucceeded {
+				wantResult = &BacklogResult{Succeeded: []metadata.Day{day}}
+			}
+			if !reflect.DeepEqual(r.result, wantResult) {
+				t.Errorf("result actual %+v != expected %+v", r.result, wantResult)
+			}
+			m, err := sc.GetMetadata(context.Background())
+			if err != nil {
+				t.Fatalf("failed to read metadata: %s", err)
+			}
+			if got := len(m.ProcessedDays) > 0; got != tc.wantSucceeded {
+				t.Errorf("metadata updated actual %t != expected %t", got, tc.wantSucceeded)
+			}
+			if len(m.FailedDays) != 0 {
+				t.Errorf("deferred day was recorded as a failure: %v", m.FailedDays)
+			}
+			files, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatalf("failed to read temporary directory: %s", err)
+			}
+			if len(files) != 0 {
+				t.Errorf("temporary directory not empty: %v", files)
+			}
+		})
+	}
+}
+
+// failingPutBackend fails the first n writes of objects whose key contains substr, or all of them if n is -1.
+type failingPutBackend struct {
+	storage.Backend
+	substr string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (b *failingPutBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	if strings.Contains(key, b.substr) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.n != 0 {
+			if b.n > 0 {
+				b.n--
+			}
+			return errors.New("service unavailable")
+		}
+	}
+	return b.Backend.Put(ctx, key, r)
+}
+
+func ptr[T any](t T) *T {
+	return &t
+}
+
+func TestBacklogEndDay(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("failed to load timezone: %s", err)
+	}
+	for _, tc := range []struct {
+		now  time.Time
+		want metadata.Day
+	}{
+		{time.Date(2022, time.January, 8, 4, 59, 0, 0, loc), metadata.NewDay(2022, time.January, 6)},
+		{time.Date(2022, time.January, 8, 5, 0, 0, 0, loc), metadata.NewDay(2022, time.January, 7)},
+		{time.Date(2022, time.January, 1, 5, 0, 0, 0, loc), metadata.NewDay(2021, time.December, 31)},
+		// 2022-03-13 is 23 hours long, because clocks go forward.
+		{time.Date(2022, time.March, 14, 4, 59, 0, 0, loc), metadata.NewDay(2022, time.March, 12)},
+		{time.Date(2022, time.March, 14, 5, 0, 0, 0, loc), metadata.NewDay(2022, time.March, 13)},
+		// 2022-11-06 is 25 hours long, because clocks go back.
+		{time.Date(2022, time.November, 7, 4, 59, 0, 0, loc), metadata.NewDay(2022, time.November, 5)},
+		{time.Date(2022, time.November, 7, 5, 0, 0, 0, loc), metadata.NewDay(2022, time.November, 6)},
+	} {
+		if got := backlogEndDay(tc.now, loc); got != tc.want {
+			t.Errorf("backlogEndDay(%s) actual %s != expected %s", tc.now, got, tc.want)
+		}
+	}
+}
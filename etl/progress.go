@@ -0,0 +1,58 @@
+package etl
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+// Progress tracks the days that are running and the stage each of them is in.
+// It is safe for concurrent use. A nil Progress tracks nothing.
+type Progress struct {
+	m    sync.Mutex
+	days map[metadata.Day]string
+}
+
+func NewProgress() *Progress {
+	return &Progress{days: map[metadata.Day]string{}}
+}
+
+// RunningDay is a day that is running.
+type RunningDay struct {
+	Day   metadata.Day `json:"day"`
+	Stage string       `json:"stage"`
+}
+
+// Running returns the days that are running, in order.
+func (p *Progress) Running() []RunningDay {
+	if p == nil {
+		return nil
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	var result []RunningDay
+	for day, stage := range p.days {
+		result = append(result, RunningDay{Day: day, Stage: stage})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Day.Before(result[j].Day) })
+	return result
+}
+
+func (p *Progress) setStage(day metadata.Day, stage string) {
+	if p == nil {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.days[day] = stage
+}
+
+func (p *Progress) finish(day metadata.Day) {
+	if p == nil {
+		return
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	delete(p.days, day)
+}
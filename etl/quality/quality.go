@@ -0,0 +1,139 @@
+// Package quality builds data quality reports for processed days.
+package quality
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+// DefaultGapThreshold is the gap threshold used if none is configured.
+const DefaultGapThreshold = 5 * time.Minute
+
+// Report describes the quality of the data for a single day.
+type Report struct {
+	NumTrips     int
+	NumStopTimes int
+
+	// Number of trips for each route ID.
+	TripsPerRoute map[string]int
+
+	// Number of trips that have no stop times.
+	TripsWithNoStopTimes int
+
+	// Number of stop times that have neither an arrival nor a departure time.
+	StopTimesWithNoTimes int
+
+	// Fraction of trips that were never marked as past.
+	FractionTripsNotMarkedPast float64
+
+	// Distribution of the number of schedule rewrites: maps a number of rewrites
+	// to the number of trips with that many rewrites.
+	NumScheduleRewrites map[int]int
+
+	// Coverage of each feed by the downloaded GTFS-RT snapshots.
+	FeedCoverage map[string]FeedCoverage
+}
+
+// FeedCoverage describes periods of the day in which no GTFS-RT snapshots are available for a feed.
+type FeedCoverage struct {
+	NumSnapshots int
+
+	// Intervals longer than the threshold in which there are no snapshots.
+	Gaps []metadata.Gap
+
+	// Total length of all gaps longer than the threshold, in minutes.
+	GapMinutes float64
+
+	// Length of the longest gap, in minutes.
+	LongestGapMinutes float64
+}
+
+// NewReport builds a quality report for the provided journal and the coverage of the feeds used to build it.
+func NewReport(j *journal.Journal, coverage map[string]FeedCoverage) *Report {
+	r := &Report{
+		TripsPerRoute:       map[string]int{},
+		NumScheduleRewrites: map[int]int{},
+		FeedCoverage:        coverage,
+	}
+	var numNotMarkedPast int
+	for _, trip := range j.Trips {
+		r.NumTrips++
+		r.TripsPerRoute[trip.RouteID]++
+		r.NumScheduleRewrites[trip.NumScheduleRewrites]++
+		if trip.MarkedPast == nil {
+			numNotMarkedPast++
+		}
+		if len(trip.StopTimes) == 0 {
+			r.TripsWithNoStopTimes++
+		}
+		for _, stopTime := range trip.StopTimes {
+			r.NumStopTimes++
+			if stopTime.ArrivalTime == nil && stopTime.DepartureTime == nil {
+				r.StopTimesWithNoTimes++
+			}
+		}
+	}
+	if r.NumTrips > 0 {
+		r.FractionTripsNotMarkedPast = float64(numNotMarkedPast) / float64(r.NumTrips)
+	}
+	return r
+}
+
+// Summary returns the summary of the report that is stored in the metadata.
+func (r *Report) Summary() *metadata.QualitySummary {
+	s := &metadata.QualitySummary{
+		NumTrips:                   r.NumTrips,
+		NumStopTimes:               r.NumStopTimes,
+		TripsWithNoStopTimes:       r.TripsWithNoStopTimes,
+		StopTimesWithNoTimes:       r.StopTimesWithNoTimes,
+		FractionTripsNotMarkedPast: r.FractionTripsNotMarkedPast,
+	}
+	for _, coverage := range r.FeedCoverage {
+		s.CoverageGapMinutes += coverage.GapMinutes
+	}
+	return s
+}
+
+// NewFeedCoverage calculates the coverage of a feed over the interval [start, end) given the times
+// of the snapshots downloaded for the feed. Periods without snapshots that are longer than the gap
+// threshold are reported as gaps. If the threshold is zero the default threshold is used.
+func NewFeedCoverage(times []time.Time, start, end time.Time, gapThreshold time.Duration) FeedCoverage {
+	if gapThreshold <= 0 {
+		gapThreshold = DefaultGapThreshold
+	}
+	var inRange []time.Time
+	for _, t := range times {
+		if t.Before(start) || !t.Before(end) {
+			continue
+		}
+		inRange = append(inRange, t)
+	}
+	sort.Slice(inRange, func(i, j int) bool {
+		return inRange[i].Before(inRange[j])
+	})
+	c := FeedCoverage{
+		NumSnapshots: len(inRange),
+		Gaps:         []metadata.Gap{},
+	}
+	previous := start
+	for _, t := range append(inRange, end) {
+		gap := t.Sub(previous)
+		gapStart := previous
+		previous = t
+		if gap <= gapThreshold {
+			continue
+		}
+		c.Gaps = append(c.Gaps, metadata.Gap{
+			Start: gapStart.In(start.Location()),
+			End:   t.In(start.Location()),
+		})
+		c.GapMinutes += gap.Minutes()
+		if gap.Minutes() > c.LongestGapMinutes {
+			c.LongestGapMinutes = gap.Minutes()
+		}
+	}
+	return c
+}
@@ -0,0 +1,97 @@
+package quality
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	j := journal.Journal{
+		Trips: []journal.Trip{
+			{
+				RouteID: "A",
+				StopTimes: []journal.StopTime{
+					{ArrivalTime: ptr(start)},
+					{},
+				},
+				MarkedPast:          ptr(start),
+				NumScheduleRewrites: 1,
+			},
+			{
+				RouteID: "A",
+				StopTimes: []journal.StopTime{
+					{DepartureTime: ptr(start)},
+				},
+			},
+			{
+				RouteID: "L",
+			},
+		},
+	}
+	snapshotTimes := map[string][]time.Time{
+		"feed": {
+			// Outside of the day, and so ignored.
+			start.Add(-time.Minute),
+			start.Add(time.Minute),
+			start.Add(2 * time.Minute),
+			// Gap of 20 minutes.
+			start.Add(22 * time.Minute),
+			// Gap of 30 minutes, followed by a gap of 8 minutes to the end of the day.
+			start.Add(52 * time.Minute),
+		},
+	}
+
+	coverage := map[string]FeedCoverage{}
+	for feedID, times := range snapshotTimes {
+		coverage[feedID] = NewFeedCoverage(times, start, end, 0)
+	}
+	r := NewReport(&j, coverage)
+
+	want := &Report{
+		NumTrips:                   3,
+		NumStopTimes:               3,
+		TripsPerRoute:              map[string]int{"A": 2, "L": 1},
+		TripsWithNoStopTimes:       1,
+		StopTimesWithNoTimes:       1,
+		FractionTripsNotMarkedPast: 2.0 / 3.0,
+		NumScheduleRewrites:        map[int]int{0: 2, 1: 1},
+		FeedCoverage: map[string]FeedCoverage{
+			"feed": {
+				NumSnapshots: 4,
+				Gaps: []metadata.Gap{
+					{Start: start.Add(2 * time.Minute), End: start.Add(22 * time.Minute)},
+					{Start: start.Add(22 * time.Minute), End: start.Add(52 * time.Minute)},
+					{Start: start.Add(52 * time.Minute), End: end},
+				},
+				GapMinutes:        58,
+				LongestGapMinutes: 30,
+			},
+		},
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("NewReport() = %+v, want %+v", r, want)
+	}
+}
+
+func ptr[T any](t T) *T {
+	return &t
+}
+
+func TestNewFeedCoverage_Threshold(t *testing.T) {
+	start := time.Date(2022, time.January, 2, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	times := []time.Time{start, start.Add(20 * time.Minute), start.Add(40 * time.Minute), end}
+
+	if c := NewFeedCoverage(times, start, end, 30*time.Minute); len(c.Gaps) != 0 {
+		t.Errorf("NewFeedCoverage(threshold=30m) gaps = %v, want none", c.Gaps)
+	}
+	if c := NewFeedCoverage(times, start, end, 10*time.Minute); len(c.Gaps) != 3 {
+		t.Errorf("NewFeedCoverage(threshold=10m) gaps = %v, want 3 gaps", c.Gaps)
+	}
+}
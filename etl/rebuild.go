@@ -0,0 +1,355 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+type RebuildMetadataOptions struct {
+	// If true, the differences between the existing and rebuilt metadata are printed but the
+	// rebuilt metadata is not written.
+	DryRun bool
+
+	// Software version to record for the rebuilt days.
+	// The version that built an artifact can't be recovered from object storage.
+	// If zero, the current software version is used so that the backlog doesn't reprocess every day.
+	SoftwareVersion int
+}
+
+// Software version recorded for rebuilt days that are missing an artifact that every version produces.
+// It is lower than every real software version, so the backlog reprocesses these days.
+const partialDaySoftwareVersion = 0
+
+// RebuildMetadata reconstructs the metadata from the artifacts in object storage.
+//
+// If there are multiple artifacts of the same kind for a day, the one referenced by the existing metadata
+// is used, or otherwise the one referenced by the newest snapshot in the metadata history. If none of them
+// is referenced, the most recently modified one is used, with ties broken by path.
+// For days that are not in the existing metadata, or whose artifacts have changed, the feeds are taken
+// from the ETL config and the data quality summary and coverage gaps are not recovered.
+// Days that are only missing artifacts introduced by later software versions are recorded with the last
+// version that didn't produce them. Days that are missing an artifact that every version produces are
+// partial and are recorded with a software version that makes the backlog reprocess them. The full checksums of rebuilt artifacts are computed by downloading
+// them, and the SHA256SUMS files of the months that changed are regenerated.
+func RebuildMetadata(ctx context.Context, opts RebuildMetadataOptions, ec *config.Config, sc *storage.Client) error {
+	objects, err := sc.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	existing, err := sc.GetMetadata(ctx)
+	existingOk := err == nil
+	if !existingOk {
+		log.Printf("Failed to read existing metadata, treating it as empty: %s", err)
+		existing = &metadata.Metadata{}
+	}
+	chosen, err := chooseArtifacts(ctx, artifactCandidates(objects, ec), existing, sc)
+	if err != nil {
+		return err
+	}
+	rebuilt, partialDays := rebuildMetadata(chosen, opts, ec)
+
+	// Days whose artifacts have not changed keep their existing entries, which contain information
+	// that can't be recovered from object storage.
+	existingDays := map[metadata.Day]metadata.ProcessedDay{}
+	for _, p := range existing.ProcessedDays {
+		existingDays[p.Day] = p
+	}
+	for i, p := range rebuilt.ProcessedDays {
+		existingDay, ok := existingDays[p.Day]
+		if !ok {
+			continue
+		}
+		// The full checksum can't be recovered from the path, so it is carried over for unchanged objects.
+		for name, a := range p.Artifacts {
+			if existingArtifact, ok := existingDay.Artifacts[name]; ok && existingArtifact.Path == a.Path {
+				a.Sha256 = existingArtifact.Sha256
+				p.Artifacts[name] = a
+			}
+		}
+		if reflect.DeepEqual(existingDay.Artifacts, p.Artifacts) {
+			rebuilt.ProcessedDays[i] = existingDay
+			delete(partialDays, p.Day)
+		}
+	}
+	if !opts.DryRun {
+		if err := computeMissingSha256s(ctx, rebuilt, existingDays, sc); err != nil {
+			return err
+		}
+	}
+	// Failure records can't be recovered from object storage either.
+	rebuilt.FailedDays = existing.FailedDays
+	changes := diffMetadata(existing, rebuilt)
+	fmt.Printf("Rebuilt metadata has %d day(s); %d change(s) from the existing metadata:\n", len(rebuilt.ProcessedDays), len(changes))
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if len(partialDays) > 0 {
+		fmt.Printf("%d day(s) were rebuilt from a partial set of artifacts and will be reprocessed by the backlog:\n", len(partialDays))
+		for _, p := range rebuilt.ProcessedDays {
+			if missing, ok := partialDays[p.Day]; ok {
+				fmt.Printf("  %s: missing %v\n", p.Day, missing)
+			}
+		}
+	}
+	if opts.DryRun {
+		fmt.Println("Skipping writing the metadata because dry run mode is on.")
+		return nil
+	}
+	if existingOk && len(changes) == 0 {
+		return nil
+	}
+	if err := sc.ReplaceMetadata(ctx, rebuilt); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	fmt.Println("Wrote rebuilt metadata.")
+	return writeSha256Sums(ctx, changedMonths(existing, rebuilt), sc)
+}
+
+// computeMissingSha256s downloads the rebuilt artifacts whose full checksum is not known and computes it.
+// Artifacts whose content doesn't match the checksum in their path are left without a full checksum,
+// so that they are not listed in the SHA256SUMS files.
+func computeMissingSha256s(ctx context.Context, rebuilt *metadata.Metadata, existingDays map[metadata.Day]metadata.ProcessedDay, sc *storage.Client) error {
+	for _, p := range rebuilt.ProcessedDays {
+		if existingDay, ok := existingDays[p.Day]; ok && reflect.DeepEqual(existingDay, p) {
+			continue
+		}
+		for _, name := range sortedKeys(p.Artifacts) {
+			a := p.Artifacts[name]
+			if a.Sha256 != "" {
+				continue
+			}
+			checksum, err := computeSha256(ctx, sc, a.Path)
+			if err != nil {
+				return fmt.Errorf("failed to compute the checksum of %s: %w", a.Path, err)
+			}
+			if !strings.HasPrefix(checksum, a.Checksum) || a.Checksum == "" {
+				fmt.Printf("Warning: %s has checksum %s, which doesn't match its path; omitting it from SHA256SUMS\n", a.Path, checksum)
+				continue
+			}
+			a.Sha256 = checksum
+			p.Artifacts[name] = a
+		}
+	}
+	return nil
+}
+
+type artifactKey struct {
+	day  metadata.Day
+	name string
+}
+
+// artifactCandidates returns the artifacts in object storage, grouped by day and artifact name.
+func artifactCandidates(objects []storage.ObjectInfo, ec *config.Config) map[artifactKey][]storage.ObjectInfo {
+	candidates := map[artifactKey][]storage.ObjectInfo{}
+	for _, object := range objects {
+		info, ok := parseArtifactPath(ec, object.Key)
+		if !ok {
+			continue
+		}
+		key := artifactKey{day: info.Day, name: info.Name}
+		candidates[key] = append(candidates[key], object)
+	}
+	return candidates
+}
+
+// chooseArtifacts chooses one artifact for each day and artifact name.
+//
+// Where there are multiple candidates, the one referenced by the existing metadata is preferred, followed by
+// the one referenced by the newest snapshot in the metadata history, and then the most recently modified one.
+func chooseArtifacts(ctx context.Context, candidates map[artifactKey][]storage.ObjectInfo, existing *metadata.Metadata, sc *storage.Client) (map[artifactKey]storage.ObjectInfo, error) {
+	chosen := map[artifactKey]storage.ObjectInfo{}
+	unresolved := map[artifactKey]bool{}
+	for key, objects := range candidates {
+		if len(objects) == 1 {
+			chosen[key] = objects[0]
+		} else {
+			unresolved[key] = true
+		}
+	}
+	resolve := func(m *metadata.Metadata) {
+		for _, p := range m.ProcessedDays {
+			for name, a := range p.Artifacts {
+				key := artifactKey{day: p.Day, name: name}
+				if !unresolved[key] {
+					continue
+				}
+				for _, object := range candidates[key] {
+					if object.Key == a.Path {
+						chosen[key] = object
+						delete(unresolved, key)
+					}
+				}
+			}
+		}
+	}
+	resolve(existing)
+	if len(unresolved) > 0 {
+		snapshots, err := sc.ListMetadataSnapshots(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list metadata snapshots: %w", err)
+		}
+		for i := len(snapshots) - 1; i >= 0 && len(unresolved) > 0; i-- {
+			m, err := sc.GetMetadataSnapshot(ctx, snapshots[i].Timestamp)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read metadata snapshot %s: %w", snapshots[i].Timestamp, err)
+			}
+			resolve(m)
+		}
+	}
+	for key := range unresolved {
+		var newest storage.ObjectInfo
+		for _, object := range candidates[key] {
+			if newest.Key == "" || object.LastModified.After(newest.LastModified) ||
+				(object.LastModified.Equal(newest.LastModified) && object.Key > newest.Key) {
+				newest = object
+			}
+		}
+		chosen[key] = newest
+	}
+	return chosen, nil
+}
+
+// rebuildMetadata builds the metadata from the chosen artifacts. It also returns the partial days, along with
+// the names of their missing artifacts.
+func rebuildMetadata(chosen map[artifactKey]storage.ObjectInfo, opts RebuildMetadataOptions, ec *config.Config) (*metadata.Metadata, map[metadata.Day][]string) {
+	version := opts.SoftwareVersion
+	if version == 0 {
+		version = softwareVersion
+	}
+	dayToProcessedDay := map[metadata.Day]*metadata.ProcessedDay{}
+	m := &metadata.Metadata{}
+	for key, object := range chosen {
+		info, _ := parseArtifactPath(ec, object.Key)
+		p, ok := dayToProcessedDay[key.day]
+		if !ok {
+			p = &metadata.ProcessedDay{
+				Day:             key.day,
+				Feeds:           config.FeedIDsForDay(ec.Feeds, key.day),
+				SoftwareVersion: version,
+				Artifacts:       map[string]metadata.Artifact{},
+			}
+			dayToProcessedDay[key.day] = p
+		}
+		p.Artifacts[key.name] = metadata.Artifact{
+			Size:     object.Size,
+			Path:     object.Key,
+			Checksum: info.Checksum,
+		}
+		if p.Created.Before(object.LastModified) {
+			p.Created = object.LastModified
+		}
+	}
+	partialDays := map[metadata.Day][]string{}
+	for _, p := range dayToProcessedDay {
+		var missing []string
+		for _, e := range exporters(p.Day, p.Feeds, ec, nil) {
+			if _, ok := p.Artifacts[e.Name()]; !ok {
+				missing = append(missing, e.Name())
+			}
+		}
+		if len(missing) > 0 {
+			p.SoftwareVersion = min(p.SoftwareVersion, lastSoftwareVersionWithout(missing))
+		}
+		if p.SoftwareVersion == partialDaySoftwareVersion {
+			sort.Strings(missing)
+			partialDays[p.Day] = missing
+		}
+		m.ProcessedDays = append(m.ProcessedDays, *p)
+	}
+	sortProcessedDays(m.ProcessedDays)
+	return m, partialDays
+}
+
+// lastSoftwareVersionWithout returns the last software version that didn't produce any of the artifacts,
+// or partialDaySoftwareVersion if one of them is produced by every version.
+func lastSoftwareVersionWithout(artifacts []string) int {
+	version := softwareVersion
+	for _, name := range artifacts {
+		introduced, ok := artifactSoftwareVersions[name]
+		if !ok {
+			return partialDaySoftwareVersion
+		}
+		version = min(version, introduced-1)
+	}
+	return version
+}
+
+// sortProcessedDays sorts processed days from newest to oldest, which is the order used in the metadata.
+func sortProcessedDays(processedDays []metadata.ProcessedDay) {
+	sort.Slice(processedDays, func(i, j int) bool {
+		return processedDays[j].Day.Before(processedDays[i].Day)
+	})
+}
+
+// diffMetadata returns a human readable description of the differences between two versions of the metadata.
+// Changes to the artifacts of a day are described in detail; other changes are summarized.
+func diffMetadata(old, new *metadata.Metadata) []string {
+	oldDays := map[metadata.Day]metadata.ProcessedDay{}
+	for _, p := range old.ProcessedDays {
+		oldDays[p.Day] = p
+	}
+	newDays := map[metadata.Day]metadata.ProcessedDay{}
+	for _, p := range new.ProcessedDays {
+		newDays[p.Day] = p
+	}
+	var allDays []metadata.ProcessedDay
+	allDays = append(allDays, old.ProcessedDays...)
+	for _, p := range new.ProcessedDays {
+		if _, ok := oldDays[p.Day]; !ok {
+			allDays = append(allDays, p)
+		}
+	}
+	sortProcessedDays(allDays)
+
+	var changes []string
+	for _, p := range allDays {
+		oldDay, inOld := oldDays[p.Day]
+		newDay, inNew := newDays[p.Day]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("+ %s: %v", p.Day, sortedKeys(newDay.Artifacts)))
+		case !inNew:
+			changes = append(changes, fmt.Sprintf("- %s: %v", p.Day, sortedKeys(oldDay.Artifacts)))
+		default:
+			names := map[string]bool{}
+			for name := range oldDay.Artifacts {
+				names[name] = true
+			}
+			for name := range newDay.Artifacts {
+				names[name] = true
+			}
+			numChanges := len(changes)
+			for _, name := range sortedKeys(names) {
+				oldArtifact, inOld := oldDay.Artifacts[name]
+				newArtifact, inNew := newDay.Artifacts[name]
+				switch {
+				case !inOld:
+					changes = append(changes, fmt.Sprintf("~ %s: + %s %s", p.Day, name, newArtifact.Path))
+				case !inNew:
+					changes = append(changes, fmt.Sprintf("~ %s: - %s %s", p.Day, name, oldArtifact.Path))
+				case oldArtifact != newArtifact:
+					changes = append(changes, fmt.Sprintf("~ %s: %s %s -> %s", p.Day, name, oldArtifact.Path, newArtifact.Path))
+				}
+			}
+			if len(changes) == numChanges && !reflect.DeepEqual(oldDay, newDay) {
+				changes = append(changes, fmt.Sprintf("~ %s: software version %d -> %d, created %s -> %s", p.Day,
+					oldDay.SoftwareVersion, newDay.SoftwareVersion,
+					oldDay.Created.Format(time.RFC3339), newDay.Created.Format(time.RFC3339)))
+			}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,257 @@
+package etl
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestRebuildMetadata(t *testing.T) {
+	ctx := context.Background()
+	jan7 := metadata.NewDay(2022, time.January, 7)
+	jan8 := metadata.NewDay(2022, time.January, 8)
+	jan9 := metadata.NewDay(2022, time.January, 9)
+	ec, sc := newTestClient(t)
+	ec.Feeds = []config.Feed{{Id: "feedID", FirstDay: jan7}}
+	// Objects are written in order, so later objects are newer.
+	for _, path := range []string{
+		"2022-01/subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz",
+		"2022-01/subwaydatanyc_2022-01-07_csv_bbbbbbbbbbbb.tar.xz",
+		"2022-01/subwaydatanyc_2022-01-07_gtfsrt_cccccccccccc.tar.xz",
+		"2022-01/subwaydatanyc_2022-01-08_csv_dddddddddddd.tar.xz",
+		"2022-01/subwaydatanyc_2022-01-09_csv_eeeeeeeeeeee.tar.xz",
+		"2022-01/notes.txt",
+	} {
+		if err := sc.Write(ctx, strings.NewReader("data"), path); err != nil {
+			t.Fatalf("failed to write object: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The metadata entry for Jan 8 is intact and should be retained as-is.
+	jan8ProcessedDay := metadata.ProcessedDay{
+		Day:             jan8,
+		Feeds:           []string{"otherFeedID"},
+		SoftwareVersion: 1,
+		Artifacts: map[string]metadata.Artifact{
+			metadata.ArtifactCsv: {
+				Size:     4,
+				Path:     "2022-01/subwaydatanyc_2022-01-08_csv_dddddddddddd.tar.xz",
+				Checksum: "dddddddddddd",
+				Sha256:   "dddddddddddd0000000000000000000000000000000000000000000000000000",
+			},
+		},
+	}
+	if err := sc.ReplaceMetadata(ctx, &metadata.Metadata{ProcessedDays: []metadata.ProcessedDay{jan8ProcessedDay}}); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+
+	if err := RebuildMetadata(ctx, RebuildMetadataOptions{DryRun: true}, ec, sc); err != nil {
+		t.Fatalf("RebuildMetadata failed: %s", err)
+	}
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	if len(m.ProcessedDays) != 1 {
+		t.Errorf("dry run modified the metadata")
+	}
+
+	if err := RebuildMetadata(ctx, RebuildMetadataOptions{}, ec, sc); err != nil {
+		t.Fatalf("RebuildMetadata failed: %s", err)
+	}
+	m, err = sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	if len(m.ProcessedDays) != 3 {
+		t.Fatalf("rebuilt metadata has %d days, expected 3", len(m.ProcessedDays))
+	}
+	jan9ProcessedDay := m.ProcessedDays[0]
+	jan9ProcessedDay.Created = time.Time{}
+	// The gtfsrt artifact, which every software version produces, is missing, so the day is partial.
+	wantJan9ProcessedDay := metadata.ProcessedDay{
+		Day:             jan9,
+		Feeds:           []string{"feedID"},
+		SoftwareVersion: partialDaySoftwareVersion,
+		Artifacts: map[string]metadata.Artifact{
+			metadata.ArtifactCsv: {
+				Size:     4,
+				Path:     "2022-01/subwaydatanyc_2022-01-09_csv_eeeeeeeeeeee.tar.xz",
+				Checksum: "eeeeeeeeeeee",
+			},
+		},
+	}
+	if !reflect.DeepEqual(jan9ProcessedDay, wantJan9ProcessedDay) {
+		t.Errorf("processed day actual %+v != expected %+v", jan9ProcessedDay, wantJan9ProcessedDay)
+	}
+	if !reflect.DeepEqual(m.ProcessedDays[1], jan8ProcessedDay) {
+		t.Errorf("processed day actual %+v != expected %+v", m.ProcessedDays[1], jan8ProcessedDay)
+	}
+	jan7ProcessedDay := m.ProcessedDays[2]
+	jan7ProcessedDay.Created = time.Time{}
+	// Only the parquet and sqlite artifacts, which were introduced in version 5, are missing, so the day is
+	// recorded with version 4.
+	wantJan7ProcessedDay := metadata.ProcessedDay{
+		Day:             jan7,
+		Feeds:           []string{"feedID"},
+		SoftwareVersion: 4,
+		Artifacts: map[string]metadata.Artifact{
+			metadata.ArtifactCsv: {
+				Size:     4,
+				Path:     "2022-01/subwaydatanyc_2022-01-07_csv_bbbbbbbbbbbb.tar.xz",
+				Checksum: "bbbbbbbbbbbb",
+			},
+			metadata.ArtifactGtfsrt: {
+				Size:     4,
+				Path:     "2022-01/subwaydatanyc_2022-01-07_gtfsrt_cccccccccccc.tar.xz",
+				Checksum: "cccccccccccc",
+			},
+		},
+	}
+	if !reflect.DeepEqual(jan7ProcessedDay, wantJan7ProcessedDay) {
+		t.Errorf("processed day actual %+v != expected %+v", jan7ProcessedDay, wantJan7ProcessedDay)
+	}
+}
+
+func TestRebuildMetadata_FullSetOfArtifacts(t *testing.T) {
+	ctx := context.Background()
+	jan7 := metadata.NewDay(2022, time.January, 7)
+	ec, sc := newTestClient(t)
+	ec.Feeds = []config.Feed{{Id: "feedID", FirstDay: jan7}}
+	var wantSums strings.Builder
+	for _, e := range exporters(jan7, []string{"feedID"}, ec, nil) {
+		content := "data for " + e.Name()
+		path := artifactPath(ec, jan7, e.Name(), checksum([]byte(content)), e.Extension())
+		if err := sc.Write(ctx, strings.NewReader(content), path); err != nil {
+			t.Fatalf("failed to write object: %s", err)
+		}
+		fmt.Fprintf(&wantSums, "%x  %s\n", sha256.Sum256([]byte(content)), path[len("2022-01/"):])
+	}
+	if err := RebuildMetadata(ctx, RebuildMetadataOptions{}, ec, sc); err != nil {
+		t.Fatalf("RebuildMetadata failed: %s", err)
+	}
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	if len(m.ProcessedDays) != 1 || m.ProcessedDays[0].SoftwareVersion != softwareVersion {
+		t.Errorf("rebuilt metadata actual %+v != expected one day with software version %d", m.ProcessedDays, softwareVersion)
+	}
+	// The full checksums are computed, so the SHA256SUMS file lists every artifact.
+	if got := readObject(t, sc, "2022-01/SHA256SUMS"); sortedLines(got) != sortedLines(wantSums.String()) {
+		t.Errorf("SHA256SUMS actual %q != expected the lines of %q", got, wantSums.String())
+	}
+}
+
+func readObject(t *testing.T, sc *storage.Client, path string) string {
+	t.Helper()
+	r, err := sc.Read(context.Background(), path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	return string(b)
+}
+
+func sortedLines(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+func TestRebuildMetadata_PrefersHistory(t *testing.T) {
+	ctx := context.Background()
+	jan7 := metadata.NewDay(2022, time.January, 7)
+	ec, sc := newTestClient(t)
+	older := "2022-01/subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz"
+	newer := "2022-01/subwaydatanyc_2022-01-07_csv_bbbbbbbbbbbb.tar.xz"
+	for _, path := range []string{older, newer} {
+		if err := sc.Write(ctx, strings.NewReader("data"), path); err != nil {
+			t.Fatalf("failed to write object: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The older object was the one that was published. The metadata is then lost.
+	if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		m.ProcessedDays = []metadata.ProcessedDay{{
+			Day:       jan7,
+			Artifacts: map[string]metadata.Artifact{metadata.ArtifactCsv: {Size: 4, Path: older}},
+		}}
+		return true
+	}); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+	if err := sc.ReplaceMetadata(ctx, &metadata.Metadata{}); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+
+	if err := RebuildMetadata(ctx, RebuildMetadataOptions{}, ec, sc); err != nil {
+		t.Fatalf("RebuildMetadata failed: %s", err)
+	}
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %s", err)
+	}
+	if len(m.ProcessedDays) != 1 || m.ProcessedDays[0].Artifacts[metadata.ArtifactCsv].Path != older {
+		t.Errorf("rebuilt metadata actual %+v != expected csv artifact %s", m.ProcessedDays, older)
+	}
+}
+
+func TestChooseArtifacts_TieBreak(t *testing.T) {
+	ctx := context.Background()
+	ec, sc := newTestClient(t)
+	modified := time.Date(2022, time.January, 8, 5, 0, 0, 0, time.UTC)
+	objects := []storage.ObjectInfo{
+		{Key: "2022-01/subwaydatanyc_2022-01-07_csv_bbbbbbbbbbbb.tar.xz", LastModified: modified},
+		{Key: "2022-01/subwaydatanyc_2022-01-07_csv_cccccccccccc.tar.xz", LastModified: modified},
+		{Key: "2022-01/subwaydatanyc_2022-01-07_csv_aaaaaaaaaaaa.tar.xz", LastModified: modified},
+	}
+	for _, order := range [][]storage.ObjectInfo{objects, {objects[2], objects[1], objects[0]}} {
+		chosen, err := chooseArtifacts(ctx, artifactCandidates(order, ec), &metadata.Metadata{}, sc)
+		if err != nil {
+			t.Fatalf("chooseArtifacts failed: %s", err)
+		}
+		key := artifactKey{day: metadata.NewDay(2022, time.January, 7), name: metadata.ArtifactCsv}
+		if got := chosen[key].Key; got != objects[1].Key {
+			t.Errorf("chosen artifact actual %s != expected %s", got, objects[1].Key)
+		}
+	}
+}
+
+func TestDiffMetadata(t *testing.T) {
+	jan7 := metadata.NewDay(2022, time.January, 7)
+	jan8 := metadata.NewDay(2022, time.January, 8)
+	jan9 := metadata.NewDay(2022, time.January, 9)
+	old := &metadata.Metadata{ProcessedDays: []metadata.ProcessedDay{
+		{Day: jan8, Artifacts: map[string]metadata.Artifact{"csv": {Path: "a"}, "gtfsrt": {Path: "b"}}},
+		{Day: jan7, Artifacts: map[string]metadata.Artifact{"csv": {Path: "c"}}},
+	}}
+	new := &metadata.Metadata{ProcessedDays: []metadata.ProcessedDay{
+		{Day: jan9, Artifacts: map[string]metadata.Artifact{"csv": {Path: "d"}}},
+		{Day: jan8, Artifacts: map[string]metadata.Artifact{"csv": {Path: "e"}, "sqlite": {Path: "f"}}},
+	}}
+	want := []string{
+		"+ 2022-01-09: [csv]",
+		"~ 2022-01-08: csv a -> e",
+		"~ 2022-01-08: - gtfsrt b",
+		"~ 2022-01-08: + sqlite f",
+		"- 2022-01-07: [csv]",
+	}
+	if got := diffMetadata(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffMetadata actual %q != expected %q", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+)
+
+// defaultRetryPolicies are the retry policies used for stages that don't have a policy in the ETL config.
+var defaultRetryPolicies = map[string]config.RetryPolicy{
+	stageDownload: {
+		MaxAttempts:    3,
+		InitialBackoff: config.Duration(30 * time.Second),
+		MaxBackoff:     config.Duration(5 * time.Minute),
+	},
+	stageUpload: {
+		MaxAttempts:    5,
+		InitialBackoff: config.Duration(5 * time.Second),
+		MaxBackoff:     config.Duration(time.Minute),
+	},
+}
+
+func retryPolicy(ec *config.Config, stage string) config.RetryPolicy {
+	if policy, ok := ec.RetryPolicies[stage]; ok {
+		return policy
+	}
+	return defaultRetryPolicies[stage]
+}
+
+// permanentError is an error that is not retried, whatever the retry policy.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func isRetryable(policy config.RetryPolicy, err error) bool {
+	if errors.As(err, &permanentError{}) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, s := range policy.NonRetryableErrors {
+		if strings.Contains(err.Error(), s) {
+			return false
+		}
+	}
+	return true
+}
+
+// retry calls f until it succeeds, it returns an error that is not retryable, the attempts allowed by the
+// policy are used up, or the context is cancelled. It returns the error from the last attempt.
+func retry(ctx context.Context, clk clock.Clock, description string, policy config.RetryPolicy, f func() error) error {
+	backoff := policy.InitialBackoff.AsDuration()
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || !isRetryable(policy, err) {
+			if attempt > 1 {
+				return fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return err
+		}
+		log.Printf("%s: attempt %d of %d failed, retrying in %s: %s", description, attempt, policy.MaxAttempts, backoff, err)
+		t := clk.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w (retry interrupted: %s)", err, ctx.Err())
+		case <-t.C():
+		}
+		backoff *= 2
+		if maxBackoff := policy.MaxBackoff.AsDuration(); maxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
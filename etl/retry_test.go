@@ -0,0 +1,63 @@
+package etl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+)
+
+func TestRetry(t *testing.T) {
+	policy := config.RetryPolicy{
+		MaxAttempts:        3,
+		InitialBackoff:     config.Duration(time.Millisecond),
+		MaxBackoff:         config.Duration(2 * time.Millisecond),
+		NonRetryableErrors: []string{"AccessDenied"},
+	}
+	for _, tc := range []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", nil, 1, false},
+		{"transient failure", []error{errors.New("timeout")}, 2, false},
+		{"persistent failure", []error{errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d")}, 3, true},
+		{"non-retryable error", []error{errors.New("AccessDenied: no")}, 1, true},
+		{"permanent error", []error{permanentError{errors.New("bad config")}}, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), clock.Real(), "test", policy, func() error {
+				attempts++
+				if attempts <= len(tc.errs) {
+					return tc.errs[attempts-1]
+				}
+				return nil
+			})
+			if attempts != tc.wantAttempts {
+				t.Errorf("attempts actual %d != expected %d", attempts, tc.wantAttempts)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("error actual %v, expected error: %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetry_ContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	policy := config.RetryPolicy{MaxAttempts: 3, InitialBackoff: config.Duration(time.Hour)}
+	attempts := 0
+	err := retry(ctx, clock.Real(), "test", policy, func() error {
+		attempts++
+		return errors.New("failed")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("retry actual (%v, %d attempts), expected an error after 1 attempt", err, attempts)
+	}
+}
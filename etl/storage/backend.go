@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+)
+
+// ErrNotFound is returned by backends when the requested object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrPreconditionFailed is returned by Backend.PutIfMatch when the object has been
+// modified since the provided ETag was read.
+var ErrPreconditionFailed = errors.New("object has been modified")
+
+// Backend is an object store in which the ETL pipeline persists its data and metadata.
+//
+// Keys are slash separated paths. Backends are safe for concurrent use.
+type Backend interface {
+	// Put writes the object with the provided key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// PutIfMatch writes the object with the provided key only if the current ETag of the
+	// object is etag. An empty etag means the object must not exist.
+	// If the condition is not satisfied ErrPreconditionFailed is returned.
+	PutIfMatch(ctx context.Context, key string, r io.Reader, etag string) error
+
+	// Get returns the content of the object with the provided key along with information
+	// about the version of the object that was read.
+	// The caller is responsible for closing the returned reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+
+	// List returns all objects whose key begins with the provided prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Delete deletes the object with the provided key.
+	// It is not an error to delete an object that does not exist.
+	Delete(ctx context.Context, key string) error
+
+	// Stat returns information about the object with the provided key.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// ObjectInfo describes an object in a backend.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+
+	// ETag identifies the version of the object. It changes every time the object is written.
+	// Backends may leave it empty in the results of List.
+	ETag string
+}
+
+// NewBackend returns the backend configured by the StorageBackend field of the config.
+func NewBackend(ec *config.Config) (Backend, error) {
+	switch ec.StorageBackend {
+	case "", "s3":
+		return newS3Backend(ec)
+	case "local":
+		if ec.LocalStoragePath == "" {
+			return nil, fmt.Errorf("the local storage backend requires LocalStoragePath to be set")
+		}
+		return newLocalBackend(ec.LocalStoragePath), nil
+	case "memory":
+		return newMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", ec.StorageBackend)
+	}
+}
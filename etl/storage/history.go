@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+// Directory, relative to the directory of the metadata, containing a snapshot of every committed version
+// of the metadata. For example, snapshots of metadata/nycsubway.json are stored in metadata/history.
+const metadataHistoryDir = "history"
+
+// Layout of the timestamps that identify metadata snapshots.
+// The timestamps are in UTC and have fixed width so that they sort chronologically.
+const metadataSnapshotLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Time for which snapshots are kept if the config doesn't specify a retention period.
+const defaultMetadataHistoryRetention = 90 * 24 * time.Hour
+
+// MetadataSnapshot is an immutable copy of a committed version of the metadata.
+type MetadataSnapshot struct {
+	Timestamp string
+	Size      int64
+}
+
+func (c *Client) metadataSnapshotPath(timestamp string) string {
+	return path.Join(path.Dir(c.ec.MetadataPath), metadataHistoryDir, timestamp+".json")
+}
+
+// writeMetadataSnapshot writes a snapshot of a version of the metadata that is about to be committed and
+// returns its timestamp.
+//
+// The snapshot is written before the version is committed so that every committed version has a snapshot.
+// If the commit fails the snapshot should be deleted.
+func (c *Client) writeMetadataSnapshot(ctx context.Context, b []byte) (string, error) {
+	timestamp := c.clock.Now().UTC().Format(metadataSnapshotLayout)
+	if err := c.backend.Put(ctx, c.key(c.metadataSnapshotPath(timestamp)), bytes.NewReader(b)); err != nil {
+		return "", fmt.Errorf("failed to write metadata snapshot %s: %w", timestamp, err)
+	}
+	return timestamp, nil
+}
+
+func (c *Client) deleteMetadataSnapshot(ctx context.Context, timestamp string) error {
+	return c.backend.Delete(ctx, c.key(c.metadataSnapshotPath(timestamp)))
+}
+
+// pruneMetadataSnapshots deletes the snapshots that are older than the retention period.
+// The newest snapshot is never deleted.
+func (c *Client) pruneMetadataSnapshots(ctx context.Context) error {
+	retention := time.Duration(c.ec.MetadataHistoryRetention)
+	if retention == 0 {
+		retention = defaultMetadataHistoryRetention
+	}
+	snapshots, err := c.ListMetadataSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	cutoff := c.clock.Now().UTC().Add(-retention).Format(metadataSnapshotLayout)
+	for i, snapshot := range snapshots {
+		if i == len(snapshots)-1 || snapshot.Timestamp >= cutoff {
+			break
+		}
+		if err := c.deleteMetadataSnapshot(ctx, snapshot.Timestamp); err != nil {
+			return fmt.Errorf("failed to delete metadata snapshot %s: %w", snapshot.Timestamp, err)
+		}
+	}
+	return nil
+}
+
+// ListMetadataSnapshots lists the snapshots of the metadata, from oldest to newest.
+func (c *Client) ListMetadataSnapshots(ctx context.Context) ([]MetadataSnapshot, error) {
+	dir := path.Join(path.Dir(c.ec.MetadataPath), metadataHistoryDir) + "/"
+	objects, err := c.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []MetadataSnapshot
+	for _, object := range objects {
+		timestamp, ok := strings.CutSuffix(strings.TrimPrefix(object.Key, dir), ".json")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(metadataSnapshotLayout, timestamp); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, MetadataSnapshot{
+			Timestamp: timestamp,
+			Size:      object.Size,
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp < snapshots[j].Timestamp
+	})
+	return snapshots, nil
+}
+
+// GetMetadataSnapshot returns the snapshot of the metadata with the provided timestamp.
+func (c *Client) GetMetadataSnapshot(ctx context.Context, timestamp string) (*metadata.Metadata, error) {
+	if _, err := time.Parse(metadataSnapshotLayout, timestamp); err != nil {
+		return nil, fmt.Errorf("invalid snapshot timestamp %q", timestamp)
+	}
+	r, err := c.Read(ctx, c.metadataSnapshotPath(timestamp))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var m metadata.Metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata snapshot %s: %w", timestamp, err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestMetadataHistory(t *testing.T) {
+	ctx := context.Background()
+	ec := &config.Config{
+		BucketPrefix: "prefix",
+		MetadataPath: "data/metadata.json",
+	}
+	b := newMemoryBackend()
+	c := NewClientWithBackend(ec, b)
+
+	days := []metadata.Day{
+		metadata.NewDay(2022, time.January, 2),
+		metadata.NewDay(2022, time.January, 3),
+	}
+	for _, day := range days {
+		day := day
+		if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+			m.ProcessedDays = append(m.ProcessedDays, metadata.ProcessedDay{Day: day})
+			return true
+		}); err != nil {
+			t.Fatalf("UpdateMetadata failed: %s", err)
+		}
+	}
+	// Updates that are not committed should not create snapshots.
+	if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		return false
+	}); err != nil {
+		t.Fatalf("UpdateMetadata failed: %s", err)
+	}
+
+	snapshots, err := c.ListMetadataSnapshots(ctx)
+	if err != nil {
+		t.Fatalf("ListMetadataSnapshots failed: %s", err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("ListMetadataSnapshots returned %d snapshots, want 2", len(snapshots))
+	}
+	if !(snapshots[0].Timestamp < snapshots[1].Timestamp) {
+		t.Errorf("snapshots %s and %s are not in chronological order", snapshots[0].Timestamp, snapshots[1].Timestamp)
+	}
+	if _, err := b.Stat(ctx, "prefix/data/history/"+snapshots[0].Timestamp+".json"); err != nil {
+		t.Errorf("snapshot not stored at the expected key: %s", err)
+	}
+	for i, snapshot := range snapshots {
+		m, err := c.GetMetadataSnapshot(ctx, snapshot.Timestamp)
+		if err != nil {
+			t.Fatalf("GetMetadataSnapshot failed: %s", err)
+		}
+		if len(m.ProcessedDays) != i+1 {
+			t.Errorf("snapshot %d has %d days, want %d", i, len(m.ProcessedDays), i+1)
+		}
+	}
+	if _, err := c.GetMetadataSnapshot(ctx, "../metadata"); err == nil {
+		t.Errorf("GetMetadataSnapshot with invalid timestamp succeeded, want error")
+	}
+}
+
+func TestMetadataSnapshotKey(t *testing.T) {
+	for _, tc := range []struct {
+		bucketPrefix string
+		metadataPath string
+		want         string
+	}{
+		{"", "metadata/nycsubway.json", "metadata/history/2022-01-07T10:00:00.000000000Z.json"},
+		{"prefix", "metadata/nycsubway.json", "prefix/metadata/history/2022-01-07T10:00:00.000000000Z.json"},
+		{"", "metadata.json", "history/2022-01-07T10:00:00.000000000Z.json"},
+	} {
+		c := NewClientWithBackend(&config.Config{BucketPrefix: tc.bucketPrefix, MetadataPath: tc.metadataPath}, newMemoryBackend())
+		if got := c.key(c.metadataSnapshotPath("2022-01-07T10:00:00.000000000Z")); got != tc.want {
+			t.Errorf("snapshot key for %s actual %s != expected %s", tc.metadataPath, got, tc.want)
+		}
+	}
+}
+
+func TestMetadataHistory_Prune(t *testing.T) {
+	ctx := context.Background()
+	ec := &config.Config{
+		MetadataPath:             "metadata.json",
+		MetadataHistoryRetention: config.Duration(time.Hour),
+	}
+	clk := clock.NewFake(time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC))
+	c := NewClientWithBackend(ec, newMemoryBackend())
+	c.SetClock(clk)
+	update := func() string {
+		t.Helper()
+		if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+			return true
+		}); err != nil {
+			t.Fatalf("UpdateMetadata failed: %s", err)
+		}
+		return clk.Now().Format(metadataSnapshotLayout)
+	}
+	checkSnapshots := func(expected ...string) {
+		t.Helper()
+		if got := snapshotTimestamps(t, c); !reflect.DeepEqual(got, expected) {
+			t.Errorf("snapshots actual %v != expected %v", got, expected)
+		}
+	}
+
+	update()
+	clk.Advance(2 * time.Hour)
+	second := update()
+	checkSnapshots(second)
+
+	// The newest snapshot is retained even if it is older than the retention period.
+	clk.Advance(3 * time.Hour)
+	if err := c.pruneMetadataSnapshots(ctx); err != nil {
+		t.Fatalf("pruneMetadataSnapshots failed: %s", err)
+	}
+	checkSnapshots(second)
+
+	clk.Advance(30 * time.Minute)
+	third := update()
+	checkSnapshots(third)
+
+	clk.Advance(30 * time.Minute)
+	fourth := update()
+	checkSnapshots(third, fourth)
+}
+
+func TestMetadataHistory_SnapshotFailures(t *testing.T) {
+	ctx := context.Background()
+	ec := &config.Config{MetadataPath: "metadata.json"}
+	b := &failingBackend{Backend: newMemoryBackend()}
+	c := NewClientWithBackend(ec, b)
+
+	// If the snapshot can't be written the metadata must not be committed.
+	b.failPut = true
+	if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		return true
+	}); err == nil {
+		t.Errorf("UpdateMetadata succeeded when the snapshot could not be written, want error")
+	}
+	if _, err := b.Stat(ctx, "metadata.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("metadata was written when the snapshot could not be written")
+	}
+
+	// If the metadata can't be committed the snapshot must be deleted.
+	b.failPut = false
+	b.failPutIfMatch = true
+	if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		return true
+	}); err == nil {
+		t.Errorf("UpdateMetadata succeeded when the metadata could not be written, want error")
+	}
+	if got := snapshotTimestamps(t, c); len(got) != 0 {
+		t.Errorf("snapshots of uncommitted metadata actual %v != expected none", got)
+	}
+}
+
+// failingBackend fails writes on demand.
+type failingBackend struct {
+	Backend
+	failPut        bool
+	failPutIfMatch bool
+}
+
+func (b *failingBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	if b.failPut {
+		return errors.New("put failed")
+	}
+	return b.Backend.Put(ctx, key, r)
+}
+
+func (b *failingBackend) PutIfMatch(ctx context.Context, key string, r io.Reader, etag string) error {
+	if b.failPutIfMatch {
+		return errors.New("conditional put failed")
+	}
+	return b.Backend.PutIfMatch(ctx, key, r, etag)
+}
+
+func snapshotTimestamps(t *testing.T, c *Client) []string {
+	t.Helper()
+	snapshots, err := c.ListMetadataSnapshots(context.Background())
+	if err != nil {
+		t.Fatalf("ListMetadataSnapshots failed: %s", err)
+	}
+	var timestamps []string
+	for _, snapshot := range snapshots {
+		timestamps = append(timestamps, snapshot.Timestamp)
+	}
+	return timestamps
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// objectStore is a store that supports the unconditional operations of a Backend.
+type objectStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// Default duration of a lease. The holder of a lease renews it well before it expires, so this only
+// bounds how long a crashed process blocks other writers.
+const defaultLeaseDuration = time.Minute
+
+// putIfMatchWithLease implements a conditional write for stores that don't support conditional writes, by
+// holding a lease on the key while comparing the ETag and writing.
+func putIfMatchWithLease(ctx context.Context, s objectStore, key string, r io.Reader, etag string, leaseDuration time.Duration) error {
+	l, err := acquireLease(ctx, s, key, leaseDuration)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lease on %s: %w", key, err)
+	}
+	defer l.release()
+	var currentETag string
+	info, err := s.Stat(ctx, key)
+	if err == nil {
+		currentETag = info.ETag
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if currentETag != etag {
+		return ErrPreconditionFailed
+	}
+	if err := l.check(); err != nil {
+		return err
+	}
+	return s.Put(ctx, key, r)
+}
+
+// lease is exclusive access to a key among processes that use leases on the same store.
+//
+// To acquire a lease a process writes a uniquely named claim object under the key's lock prefix and then
+// lists the prefix. It holds the lease if its claim is the only live claim; otherwise it deletes its claim
+// and tries again later. Because listings are strongly consistent, of two processes that claim at the same
+// time at least one sees the other's claim, so at most one holds the lease. Both may back off, in which case
+// the randomized retry delay lets one of them win next time.
+//
+// Claims are live until they are older than the lease duration. Ages are measured using the store's
+// modification times, relative to the new claim, so clock skew between processes doesn't matter. The
+// holder rewrites its claim periodically to keep it live, and stops writing once it can no longer be sure
+// its claim is live.
+type lease struct {
+	s        objectStore
+	key      string
+	claimKey string
+	duration time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+
+	m sync.Mutex
+	// Time before which the claim is known to be live. Renewals extend it.
+	validUntil time.Time
+}
+
+func leasePrefix(key string) string {
+	return key + ".lease/"
+}
+
+func acquireLease(ctx context.Context, s objectStore, key string, duration time.Duration) (*lease, error) {
+	prefix := leasePrefix(key)
+	for attempt := 0; ; attempt++ {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		l := &lease{
+			s:        s,
+			key:      key,
+			claimKey: prefix + hex.EncodeToString(id),
+			duration: duration,
+			stop:     make(chan struct{}),
+			done:     make(chan struct{}),
+		}
+		acquired, err := l.claim(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			go l.renew()
+			return l, nil
+		}
+		// Randomized backoff so that competing processes don't retry in lockstep.
+		maxBackoff := 50 * time.Millisecond << min(attempt, 5)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(mathrand.Int63n(int64(maxBackoff)))):
+		}
+	}
+}
+
+// claim writes the claim object and returns whether it is the only live claim.
+func (l *lease) claim(ctx context.Context, prefix string) (bool, error) {
+	start := time.Now()
+	if err := l.s.Put(ctx, l.claimKey, bytes.NewReader(nil)); err != nil {
+		return false, err
+	}
+	claims, err := l.s.List(ctx, prefix)
+	if err != nil {
+		_ = l.s.Delete(context.WithoutCancel(ctx), l.claimKey)
+		return false, err
+	}
+	var own *ObjectInfo
+	for i := range claims {
+		if claims[i].Key == l.claimKey {
+			own = &claims[i]
+		}
+	}
+	acquired := own != nil
+	for _, c := range claims {
+		if own == nil || c.Key == l.claimKey {
+			continue
+		}
+		if own.LastModified.Sub(c.LastModified) >= l.duration {
+			log.Printf("Deleting expired lease claim %s", c.Key)
+			_ = l.s.Delete(ctx, c.Key)
+			continue
+		}
+		acquired = false
+	}
+	if !acquired {
+		_ = l.s.Delete(context.WithoutCancel(ctx), l.claimKey)
+		return false, nil
+	}
+	// The claim was written after start, so it is live until at least start plus the lease duration.
+	l.validUntil = start.Add(l.duration)
+	return true, nil
+}
+
+func (l *lease) renew() {
+	defer close(l.done)
+	t := time.NewTicker(l.duration / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+		}
+		start := time.Now()
+		if err := l.check(); err != nil {
+			return
+		}
+		if err := l.s.Put(context.Background(), l.claimKey, bytes.NewReader(nil)); err != nil {
+			log.Printf("Failed to renew lease claim %s: %s", l.claimKey, err)
+			continue
+		}
+		l.m.Lock()
+		l.validUntil = start.Add(l.duration)
+		l.m.Unlock()
+	}
+}
+
+// check returns an error if the lease may have expired, e.g. because renewals failed or the process was
+// suspended. A margin is kept so that a write started now completes before another process can claim.
+func (l *lease) check() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if remaining := time.Until(l.validUntil); remaining < l.duration/2 {
+		return fmt.Errorf("lease on %s may have expired", l.key)
+	}
+	return nil
+}
+
+func (l *lease) release() {
+	close(l.stop)
+	<-l.done
+	if err := l.s.Delete(context.Background(), l.claimKey); err != nil {
+		log.Printf("Failed to release lease claim %s: %s", l.claimKey, err)
+	}
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of the temporary and lock files the local backend creates alongside objects.
+const localTmpPrefix = ".subwaydatanyc-put-"
+
+type localBackend struct {
+	root string
+}
+
+func newLocalBackend(root string) *localBackend {
+	return &localBackend{root: root}
+}
+
+func (b *localBackend) path(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(key))
+}
+
+func (b *localBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	p := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// The object is written to a temporary file and then renamed so that readers never see a partial object.
+	f, err := os.CreateTemp(filepath.Dir(p), localTmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// PutIfMatch holds an advisory lock on a lock file for the key while comparing the ETag and writing,
+// so that conditional writes from multiple processes sharing the directory are serialized.
+// The lock is released by the operating system if the process holding it exits, so a crashed
+// writer never blocks others, and a live writer never loses its lock however long the write takes.
+func (b *localBackend) PutIfMatch(ctx context.Context, key string, r io.Reader, etag string) error {
+	p := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(ctx, filepath.Join(filepath.Dir(p), localTmpPrefix+filepath.Base(p)+".lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	var currentETag string
+	info, err := b.Stat(ctx, key)
+	if err == nil {
+		currentETag = info.ETag
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if currentETag != etag {
+		return ErrPreconditionFailed
+	}
+	return b.Put(ctx, key, r)
+}
+
+func (b *localBackend) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	f, err := os.Open(b.path(key))
+	if err != nil {
+		return nil, ObjectInfo{}, convertLocalError(err)
+	}
+	// Stat and hash the open file rather than the path so that the info matches the content being read
+	// even if the object is replaced concurrently.
+	info, err := fileInfo(key, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return f, info, nil
+}
+
+func (b *localBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var result []ObjectInfo
+	err := filepath.WalkDir(b.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), localTmpPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		// Listings don't include the ETag, as computing it requires reading the object.
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		result = append(result, ObjectInfo{
+			Key:          key,
+			Size:         stat.Size(),
+			LastModified: stat.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *localBackend) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(b.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *localBackend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	return statFile(key, b.path(key))
+}
+
+func statFile(key, p string) (ObjectInfo, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return ObjectInfo{}, convertLocalError(err)
+	}
+	defer f.Close()
+	return fileInfo(key, f)
+}
+
+// fileInfo returns information about the file, which must be positioned at its start.
+//
+// The ETag is the SHA-256 hash of the content. Unlike an ETag derived from the modification time and
+// size, it is guaranteed to change when the content changes, however coarse the file system's clock.
+// Computing it requires reading the object, so it is only done by Stat and Get, which conditional writes use.
+func fileInfo(key string, f *os.File) (ObjectInfo, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+		ETag:         hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func convertLocalError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
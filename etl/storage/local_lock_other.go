@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+func lockFile(ctx context.Context, lockPath string) (func(), error) {
+	return nil, fmt.Errorf("conditional writes with the local storage backend are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryBackend struct {
+	mutex      sync.RWMutex
+	objects    map[string]memoryObject
+	generation int64
+}
+
+type memoryObject struct {
+	content      []byte
+	lastModified time.Time
+	generation   int64
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{objects: map[string]memoryObject{}}
+}
+
+func (b *memoryBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.put(key, content)
+	return nil
+}
+
+func (b *memoryBackend) PutIfMatch(ctx context.Context, key string, r io.Reader, etag string) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var currentETag string
+	if o, ok := b.objects[key]; ok {
+		currentETag = o.etag()
+	}
+	if currentETag != etag {
+		return ErrPreconditionFailed
+	}
+	b.put(key, content)
+	return nil
+}
+
+func (b *memoryBackend) put(key string, content []byte) {
+	b.generation++
+	b.objects[key] = memoryObject{content: content, lastModified: time.Now(), generation: b.generation}
+}
+
+func (b *memoryBackend) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	o, ok := b.objects[key]
+	if !ok {
+		return nil, ObjectInfo{}, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(o.content)), o.info(key), nil
+}
+
+func (b *memoryBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	var result []ObjectInfo
+	for key, o := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			result = append(result, o.info(key))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+func (b *memoryBackend) Delete(ctx context.Context, key string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *memoryBackend) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	o, ok := b.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return o.info(key), nil
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.content)),
+		LastModified: o.lastModified,
+		ETag:         o.etag(),
+	}
+}
+
+func (o memoryObject) etag() string {
+	return strconv.FormatInt(o.generation, 10)
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+)
+
+// s3Backend stores objects in an S3 compatible bucket.
+type s3Backend struct {
+	objectStore
+	leaseDuration time.Duration
+}
+
+// s3Objects implements the unconditional operations of the backend using the S3 API.
+type s3Objects struct {
+	bucket   string
+	sc       *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func newS3Backend(ec *config.Config) (*s3Backend, error) {
+	s3Config := &aws.Config{
+		Credentials: credentials.NewStaticCredentials(ec.BucketAccessKey, ec.BucketSecretKey, ""),
+		Endpoint:    aws.String(ec.BucketUrl),
+		Region:      aws.String("us-east-1"),
+	}
+
+	newSession, err := session.NewSession(s3Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize object storage client: %w", err)
+	}
+	sc := s3.New(newSession)
+	return &s3Backend{
+		objectStore: &s3Objects{
+			bucket:   ec.BucketName,
+			sc:       sc,
+			uploader: s3manager.NewUploaderWithClient(sc),
+		},
+		leaseDuration: defaultLeaseDuration,
+	}, nil
+}
+
+// PutIfMatch holds a lease on the key while comparing the ETag and writing.
+//
+// The version of the S3 API we use does not support conditional writes, so the lease provides mutual
+// exclusion between writers instead. It relies on the bucket listing objects with strong consistency,
+// as S3 does.
+func (b *s3Backend) PutIfMatch(ctx context.Context, key string, r io.Reader, etag string) error {
+	return putIfMatchWithLease(ctx, b.objectStore, key, r, etag, b.leaseDuration)
+}
+
+// Put uploads the object using a multipart upload if it is large. At most a few parts are
+// held in memory at once, regardless of the size of the object.
+func (b *s3Objects) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := b.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+		ACL:    aws.String("public-read"),
+	})
+	return convertS3Error(err)
+}
+
+func (b *s3Objects) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	o, err := b.sc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, convertS3Error(err)
+	}
+	return o.Body, ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(o.ContentLength),
+		LastModified: aws.TimeValue(o.LastModified),
+		ETag:         aws.StringValue(o.ETag),
+	}, nil
+}
+
+func (b *s3Objects) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var result []ObjectInfo
+	err := b.sc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, o := range page.Contents {
+			result = append(result, ObjectInfo{
+				Key:          aws.StringValue(o.Key),
+				Size:         aws.Int64Value(o.Size),
+				LastModified: aws.TimeValue(o.LastModified),
+				ETag:         aws.StringValue(o.ETag),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+	return result, nil
+}
+
+func (b *s3Objects) Delete(ctx context.Context, key string) error {
+	_, err := b.sc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	return convertS3Error(err)
+}
+
+func (b *s3Objects) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	o, err := b.sc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, convertS3Error(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(o.ContentLength),
+		LastModified: aws.TimeValue(o.LastModified),
+		ETag:         aws.StringValue(o.ETag),
+	}, nil
+}
+
+func convertS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if a, ok := err.(awserr.Error); ok {
+		// HEAD requests have no response body and so S3 reports a generic NotFound code.
+		if a.Code() == s3.ErrCodeNoSuchKey || a.Code() == "NotFound" {
+			return fmt.Errorf("%w: %s", ErrNotFound, err)
+		}
+	}
+	return err
+}
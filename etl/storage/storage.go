@@ -4,105 +4,224 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
+	"log"
+	"math/rand"
+	"path"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
 	"github.com/jamespfennell/subwaydata.nyc/etl/config"
 	"github.com/jamespfennell/subwaydata.nyc/metadata"
 )
 
 type Client struct {
 	ec            *config.Config
-	sc            *s3.S3
+	backend       Backend
+	clock         clock.Clock
 	metadataMutex sync.RWMutex
 }
 
+// NewClient returns a client for the storage backend selected in the ETL config.
 func NewClient(ec *config.Config) (*Client, error) {
-	s3Config := &aws.Config{
-		Credentials: credentials.NewStaticCredentials(ec.BucketAccessKey, ec.BucketSecretKey, ""),
-		Endpoint:    aws.String(ec.BucketUrl),
-		Region:      aws.String("us-east-1"),
-	}
-
-	newSession, err := session.NewSession(s3Config)
+	backend, err := NewBackend(ec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize object storage client: %w", err)
+		return nil, err
 	}
-	return &Client{ec: ec, sc: s3.New(newSession)}, nil
+	return NewClientWithBackend(ec, backend), nil
 }
 
-func (c *Client) Write(ctx context.Context, b []byte, remotePath string) error {
-	ctx, cancel := context.WithDeadline(ctx, time.Now().UTC().Add(5*60*time.Second))
+// NewClientWithBackend returns a client that uses the provided storage backend.
+func NewClientWithBackend(ec *config.Config, backend Backend) *Client {
+	return &Client{ec: ec, backend: backend, clock: clock.Real()}
+}
+
+// SetClock sets the clock used to timestamp and prune metadata snapshots. It defaults to the system clock.
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clock.OrReal(clk)
+}
+
+// Maximum time a single write may take. Data artifacts can be multiple gigabytes.
+const writeTimeout = 60 * time.Minute
+
+// Write writes the content of the reader to the object at the provided path.
+// The content is streamed to the backend and is not buffered in memory in full.
+func (c *Client) Write(ctx context.Context, r io.Reader, remotePath string) error {
+	ctx, cancel := context.WithDeadline(ctx, time.Now().UTC().Add(writeTimeout))
 	defer cancel()
-	object := s3.PutObjectInput{
-		Bucket: aws.String(c.ec.BucketName),
-		Key:    aws.String(filepath.Join(c.ec.BucketPrefix, remotePath)),
-		Body:   bytes.NewReader(b),
-		ACL:    aws.String("public-read"),
-	}
-	_, err := c.sc.PutObjectWithContext(ctx, &object)
-	if err != nil {
+	if err := c.backend.Put(ctx, c.key(remotePath), r); err != nil {
 		return fmt.Errorf("failed to copy bytes to object storage: %w", err)
 	}
 	return nil
 }
 
+// Read returns the content of the object at the provided path.
+func (c *Client) Read(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	r, _, err := c.backend.Get(ctx, c.key(remotePath))
+	return r, err
+}
+
+// List returns all objects whose path begins with the provided prefix.
+// The keys of the returned objects are relative to the bucket prefix.
+func (c *Client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	bucketPrefix := c.ec.BucketPrefix
+	if bucketPrefix != "" {
+		bucketPrefix = strings.TrimSuffix(bucketPrefix, "/") + "/"
+	}
+	objects, err := c.backend.List(ctx, bucketPrefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i := range objects {
+		objects[i].Key = strings.TrimPrefix(objects[i].Key, bucketPrefix)
+	}
+	return objects, nil
+}
+
+// Delete deletes the object at the provided path.
+func (c *Client) Delete(ctx context.Context, remotePath string) error {
+	return c.backend.Delete(ctx, c.key(remotePath))
+}
+
+// Stat returns information about the object at the provided path.
+func (c *Client) Stat(ctx context.Context, remotePath string) (ObjectInfo, error) {
+	info, err := c.backend.Stat(ctx, c.key(remotePath))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info.Key = remotePath
+	return info, nil
+}
+
+func (c *Client) key(remotePath string) string {
+	return path.Join(c.ec.BucketPrefix, remotePath)
+}
+
 func (c *Client) GetMetadata(ctx context.Context) (*metadata.Metadata, error) {
 	c.metadataMutex.RLock()
 	defer c.metadataMutex.RUnlock()
+	m, _, err := c.getMetadata(ctx)
+	return m, err
+}
+
+// getMetadata returns the metadata along with the ETag of the version that was read.
+// The ETag is empty if no metadata has been written yet.
+func (c *Client) getMetadata(ctx context.Context) (*metadata.Metadata, string, error) {
 	ctx, cancel := context.WithDeadline(ctx, time.Now().UTC().Add(5*60*time.Second))
 	defer cancel()
-	o, err := c.sc.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(c.ec.BucketName),
-		Key:    aws.String(filepath.Join(c.ec.BucketPrefix, c.ec.MetadataPath)),
-	})
+	r, info, err := c.backend.Get(ctx, c.key(c.ec.MetadataPath))
 	if err != nil {
-		if a, ok := err.(awserr.Error); ok {
-			if a.Code() == s3.ErrCodeNoSuchKey {
-				return &metadata.Metadata{}, nil
-			}
+		if errors.Is(err, ErrNotFound) {
+			return &metadata.Metadata{}, "", nil
 		}
+		return nil, "", err
 	}
-	defer o.Body.Close()
-	b, err := io.ReadAll(o.Body)
+	defer r.Close()
+	b, err := io.ReadAll(r)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	var m metadata.Metadata
 	if err := json.Unmarshal(b, &m); err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return &m, nil
+	return &m, info.ETag, nil
 }
 
+// UpdateMetadataFunc mutates the metadata and returns whether the mutation should be committed.
+//
+// The function may be called multiple times during a single update, each time with a fresh copy of the
+// metadata, so it must not assume that it is being applied to the metadata it was first called with.
 type UpdateMetadataFunc func(*metadata.Metadata) bool
 
+// Maximum number of times UpdateMetadata will try to commit before giving up.
+const maxMetadataUpdateAttempts = 10
+
 // UpdateMetadata updates the metadata stored in the object storage.
+//
+// The metadata is only written if it has not been modified since it was read.
+// If another process modified it in the meantime, the metadata is read again and the update
+// function is re-applied to the new version.
 func (c *Client) UpdateMetadata(ctx context.Context, f UpdateMetadataFunc) error {
-	m, err := c.GetMetadata(ctx)
-	if err != nil {
-		return err
+	c.metadataMutex.Lock()
+	defer c.metadataMutex.Unlock()
+	for attempt := 1; ; attempt++ {
+		m, etag, err := c.getMetadata(ctx)
+		if err != nil {
+			return err
+		}
+		if commit := f(m); !commit {
+			return nil
+		}
+		sort.Sort(sort.Reverse(byDay(m.ProcessedDays)))
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = c.writeMetadata(ctx, b, etag)
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return err
+		}
+		if attempt == maxMetadataUpdateAttempts {
+			return fmt.Errorf("metadata was modified concurrently on each of %d attempts: %w", attempt, err)
+		}
+		log.Printf("Metadata was modified concurrently; re-applying update (attempt %d)", attempt+1)
+		// Randomized backoff so that competing writers don't retry in lockstep.
+		backoff := time.Duration(rand.Int63n(int64(100*time.Millisecond) << attempt))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
 	}
+}
+
+// ReplaceMetadata replaces the metadata stored in object storage, whether or not the existing metadata
+// can be parsed. It is intended for recovering from lost or corrupted metadata.
+//
+// ErrPreconditionFailed is returned if the metadata is modified by another process during the replacement.
+func (c *Client) ReplaceMetadata(ctx context.Context, m *metadata.Metadata) error {
 	c.metadataMutex.Lock()
 	defer c.metadataMutex.Unlock()
-	if commit := f(m); !commit {
-		return nil
+	var etag string
+	info, err := c.backend.Stat(ctx, c.key(c.ec.MetadataPath))
+	if err == nil {
+		etag = info.ETag
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
 	}
 	sort.Sort(sort.Reverse(byDay(m.ProcessedDays)))
 	b, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
 		return err
 	}
-	return c.Write(ctx, b, c.ec.MetadataPath)
+	return c.writeMetadata(ctx, b, etag)
+}
+
+// writeMetadata commits a new version of the metadata and records a snapshot of it in the metadata history.
+func (c *Client) writeMetadata(ctx context.Context, b []byte, etag string) error {
+	ctx, cancel := context.WithDeadline(ctx, time.Now().UTC().Add(5*60*time.Second))
+	defer cancel()
+	timestamp, err := c.writeMetadataSnapshot(ctx, b)
+	if err != nil {
+		return err
+	}
+	if err := c.backend.PutIfMatch(ctx, c.key(c.ec.MetadataPath), bytes.NewReader(b), etag); err != nil {
+		if deleteErr := c.deleteMetadataSnapshot(context.WithoutCancel(ctx), timestamp); deleteErr != nil {
+			log.Printf("Failed to delete snapshot %s of uncommitted metadata: %s", timestamp, deleteErr)
+		}
+		return err
+	}
+	// Snapshots are pruned on every write, so a failure here is retried by the next write.
+	if err := c.pruneMetadataSnapshots(ctx); err != nil {
+		log.Printf("Failed to prune metadata snapshots: %s", err)
+	}
+	return nil
 }
 
 type byDay []metadata.ProcessedDay
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestBackends(t *testing.T) {
+	backends := map[string]func(t *testing.T) Backend{
+		"local": func(t *testing.T) Backend {
+			return newLocalBackend(t.TempDir())
+		},
+		"memory": func(t *testing.T) Backend {
+			return newMemoryBackend()
+		},
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			b := newBackend(t)
+
+			if _, _, err := b.Get(ctx, "a/b.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+			}
+			if _, err := b.Stat(ctx, "a/b.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat(missing) error = %v, want ErrNotFound", err)
+			}
+			for key, content := range map[string]string{
+				"a/b.txt":   "hello",
+				"a/c/d.txt": "world!",
+				"e.txt":     "other",
+			} {
+				if err := b.Put(ctx, key, strings.NewReader(content)); err != nil {
+					t.Fatalf("Put(%s) failed: %s", key, err)
+				}
+			}
+
+			r, _, err := b.Get(ctx, "a/b.txt")
+			if err != nil {
+				t.Fatalf("Get failed: %s", err)
+			}
+			content, _ := io.ReadAll(r)
+			r.Close()
+			if string(content) != "hello" {
+				t.Errorf("Get content = %q, want %q", content, "hello")
+			}
+
+			info, err := b.Stat(ctx, "a/c/d.txt")
+			if err != nil {
+				t.Fatalf("Stat failed: %s", err)
+			}
+			if info.Size != 6 {
+				t.Errorf("Stat size = %d, want 6", info.Size)
+			}
+
+			objects, err := b.List(ctx, "a/")
+			if err != nil {
+				t.Fatalf("List failed: %s", err)
+			}
+			if got, want := keys(objects), []string{"a/b.txt", "a/c/d.txt"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("List keys = %v, want %v", got, want)
+			}
+			for _, o := range objects {
+				if o.Size == 0 || o.LastModified.IsZero() {
+					t.Errorf("List object %s has size %d and modification time %s", o.Key, o.Size, o.LastModified)
+				}
+			}
+
+			if err := b.Delete(ctx, "a/b.txt"); err != nil {
+				t.Fatalf("Delete failed: %s", err)
+			}
+			if err := b.Delete(ctx, "a/b.txt"); err != nil {
+				t.Errorf("Delete(missing) failed: %s", err)
+			}
+			if _, err := b.Stat(ctx, "a/b.txt"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat(deleted) error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestBackendsPutIfMatch(t *testing.T) {
+	for name, b := range map[string]Backend{
+		"local":  newLocalBackend(t.TempDir()),
+		"memory": newMemoryBackend(),
+		"s3":     &s3Backend{objectStore: newMemoryBackend(), leaseDuration: time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := b.PutIfMatch(ctx, "key", strings.NewReader("v1"), "stale"); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("PutIfMatch(missing, stale) error = %v, want ErrPreconditionFailed", err)
+			}
+			if err := b.PutIfMatch(ctx, "key", strings.NewReader("v1"), ""); err != nil {
+				t.Fatalf("PutIfMatch(missing, \"\") failed: %s", err)
+			}
+			if err := b.PutIfMatch(ctx, "key", strings.NewReader("v1"), ""); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("PutIfMatch(existing, \"\") error = %v, want ErrPreconditionFailed", err)
+			}
+			r, info, err := b.Get(ctx, "key")
+			if err != nil {
+				t.Fatalf("Get failed: %s", err)
+			}
+			r.Close()
+			if err := b.PutIfMatch(ctx, "key", strings.NewReader("v2"), info.ETag); err != nil {
+				t.Fatalf("PutIfMatch(existing, current) failed: %s", err)
+			}
+			if err := b.PutIfMatch(ctx, "key", strings.NewReader("v3"), info.ETag); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("PutIfMatch(existing, stale) error = %v, want ErrPreconditionFailed", err)
+			}
+		})
+	}
+}
+
+func TestClientMetadata(t *testing.T) {
+	ctx := context.Background()
+	ec := &config.Config{
+		BucketPrefix: "prefix",
+		MetadataPath: "metadata.json",
+	}
+	c := NewClientWithBackend(ec, newMemoryBackend())
+
+	m, err := c.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %s", err)
+	}
+	if len(m.ProcessedDays) != 0 {
+		t.Errorf("GetMetadata returned %d days, want 0", len(m.ProcessedDays))
+	}
+
+	for _, day := range []metadata.Day{
+		metadata.NewDay(2022, time.January, 2),
+		metadata.NewDay(2022, time.January, 3),
+	} {
+		day := day
+		if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+			m.ProcessedDays = append(m.ProcessedDays, metadata.ProcessedDay{Day: day})
+			return true
+		}); err != nil {
+			t.Fatalf("UpdateMetadata failed: %s", err)
+		}
+	}
+
+	m, err = c.GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %s", err)
+	}
+	var days []metadata.Day
+	for _, processedDay := range m.ProcessedDays {
+		days = append(days, processedDay.Day)
+	}
+	want := []metadata.Day{
+		metadata.NewDay(2022, time.January, 3),
+		metadata.NewDay(2022, time.January, 2),
+	}
+	if !reflect.DeepEqual(days, want) {
+		t.Errorf("metadata days = %v, want %v", days, want)
+	}
+
+	// The prefix excludes the metadata history.
+	objects, err := c.List(ctx, "metadata.")
+	if err != nil {
+		t.Fatalf("List failed: %s", err)
+	}
+	if got, want := keys(objects), []string{"metadata.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List keys = %v, want %v", got, want)
+	}
+}
+
+// Multiple clients simulate multiple processes sharing the same bucket.
+func TestClientMetadata_ConcurrentUpdates(t *testing.T) {
+	for name, newBackend := range map[string]func(t *testing.T) Backend{
+		"local": func(t *testing.T) Backend {
+			return newLocalBackend(t.TempDir())
+		},
+		"memory": func(t *testing.T) Backend {
+			return newMemoryBackend()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testConcurrentUpdates(t, newBackend(t), 8)
+		})
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, backend Backend, numClients int) {
+	ctx := context.Background()
+	ec := &config.Config{MetadataPath: "metadata.json"}
+
+	day := metadata.NewDay(2022, time.January, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < numClients; i++ {
+		c := NewClientWithBackend(ec, backend)
+		day = day.Next()
+		day := day
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+				m.ProcessedDays = append(m.ProcessedDays, metadata.ProcessedDay{Day: day})
+				return true
+			}); err != nil {
+				t.Errorf("UpdateMetadata failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, err := NewClientWithBackend(ec, backend).GetMetadata(ctx)
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %s", err)
+	}
+	if len(m.ProcessedDays) != numClients {
+		t.Errorf("metadata has %d days, want %d: %v", len(m.ProcessedDays), numClients, m.ProcessedDays)
+	}
+}
+
+func TestLocalBackend_ETagChangesWithContent(t *testing.T) {
+	ctx := context.Background()
+	b := newLocalBackend(t.TempDir())
+	var etags []string
+	// Writes of the same size in quick succession are likely to have the same modification time.
+	for _, content := range []string{"aa", "bb", "aa"} {
+		if err := b.Put(ctx, "key", strings.NewReader(content)); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		info, err := b.Stat(ctx, "key")
+		if err != nil {
+			t.Fatalf("Stat failed: %s", err)
+		}
+		etags = append(etags, info.ETag)
+	}
+	if etags[0] == etags[1] {
+		t.Errorf("ETags of different content are equal: %s", etags[0])
+	}
+	if etags[0] != etags[2] {
+		t.Errorf("ETags of the same content differ: %s != %s", etags[0], etags[2])
+	}
+}
+
+func keys(objects []ObjectInfo) []string {
+	var result []string
+	for _, o := range objects {
+		result = append(result, o.Key)
+	}
+	return result
+}
+
+// slowStore simulates the latency of a remote object store, which widens the window for races between
+// concurrent writers.
+type slowStore struct {
+	objectStore
+}
+
+func (s slowStore) delay() {
+	time.Sleep(time.Duration(rand.Int63n(int64(2 * time.Millisecond))))
+}
+
+func (s slowStore) Put(ctx context.Context, key string, r io.Reader) error {
+	s.delay()
+	return s.objectStore.Put(ctx, key, r)
+}
+
+func (s slowStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.delay()
+	return s.objectStore.List(ctx, prefix)
+}
+
+func (s slowStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.delay()
+	return s.objectStore.Stat(ctx, key)
+}
+
+// The S3 backend is run against an in-memory store that, like the S3 API we use, has no conditional writes.
+func TestS3Backend_ConcurrentUpdates(t *testing.T) {
+	testConcurrentUpdates(t, &s3Backend{
+		objectStore:   slowStore{newMemoryBackend()},
+		leaseDuration: time.Second,
+	}, 4)
+}
+
+func TestS3Backend_ExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryBackend()
+	b := &s3Backend{objectStore: store, leaseDuration: 50 * time.Millisecond}
+	// A claim left behind by a process that crashed while holding the lease.
+	if err := store.Put(ctx, leasePrefix("key")+"crashed", strings.NewReader("")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	start := time.Now()
+	if err := b.PutIfMatch(ctx, "key", strings.NewReader("v1"), ""); err != nil {
+		t.Fatalf("PutIfMatch failed: %s", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("PutIfMatch took %s, expected it to wait for the claim to expire", d)
+	}
+	claims, err := store.List(ctx, leasePrefix("key"))
+	if err != nil {
+		t.Fatalf("List failed: %s", err)
+	}
+	if len(claims) != 0 {
+		t.Errorf("claims remain after PutIfMatch: %v", keys(claims))
+	}
+}
@@ -0,0 +1,203 @@
+package etl
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+	"github.com/jamespfennell/xz"
+)
+
+type VerifyOptions struct {
+	// Days to verify. If empty, all processed days are verified.
+	Days []metadata.Day
+
+	// If true, artifacts are downloaded to verify their checksums and contents.
+	Download bool
+}
+
+// VerifyReport is the result of verifying the metadata against the contents of object storage.
+type VerifyReport struct {
+	NumDays      int
+	NumArtifacts int
+	Problems     []VerifyProblem
+}
+
+// VerifyProblem is a problem found with an artifact.
+type VerifyProblem struct {
+	Day      metadata.Day
+	Artifact string
+	Path     string
+	Problem  string
+}
+
+// Verify checks that the artifacts referenced by the metadata exist in object storage and are intact.
+//
+// Problems with artifacts, including failures to read them, are recorded in the report; an error is only
+// returned if the metadata could not be read or the context is done.
+func Verify(ctx context.Context, opts VerifyOptions, ec *config.Config, sc *storage.Client) (*VerifyReport, error) {
+	m, err := sc.GetMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain metadata: %w", err)
+	}
+	daysSet := map[metadata.Day]bool{}
+	for _, day := range opts.Days {
+		daysSet[day] = true
+	}
+	report := &VerifyReport{
+		Problems: []VerifyProblem{},
+	}
+	for _, processedDay := range m.ProcessedDays {
+		if len(daysSet) > 0 && !daysSet[processedDay.Day] {
+			continue
+		}
+		report.NumDays++
+		expectedMembers := map[string][]string{}
+		for _, e := range exporters(processedDay.Day, processedDay.Feeds, ec, nil) {
+			expectedMembers[e.Name()] = e.ExpectedMembers()
+		}
+		for _, name := range sortedKeys(processedDay.Artifacts) {
+			a := processedDay.Artifacts[name]
+			report.NumArtifacts++
+			problems := verifyArtifact(ctx, sc, a, expectedMembers[name], opts.Download)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for _, problem := range problems {
+				report.Problems = append(report.Problems, VerifyProblem{
+					Day:      processedDay.Day,
+					Artifact: name,
+					Path:     a.Path,
+					Problem:  problem,
+				})
+			}
+		}
+	}
+	return report, nil
+}
+
+func verifyArtifact(ctx context.Context, sc *storage.Client, a metadata.Artifact, expectedMembers []string, download bool) []string {
+	info, err := sc.Stat(ctx, a.Path)
+	if errors.Is(err, storage.ErrNotFound) {
+		return []string{"object does not exist"}
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("failed to stat object: %s", err)}
+	}
+	var problems []string
+	if info.Size != a.Size {
+		problems = append(problems, fmt.Sprintf("object has size %d, expected %d", info.Size, a.Size))
+	}
+	if !download {
+		return problems
+	}
+
+	r, err := sc.Read(ctx, a.Path)
+	if err != nil {
+		return append(problems, fmt.Sprintf("failed to read object: %s", err))
+	}
+	defer r.Close()
+	h := sha256.New()
+	tr := io.TeeReader(r, h)
+	if problem := verifyContents(tr, a.Extension(), expectedMembers); problem != "" {
+		problems = append(problems, problem)
+	}
+	// The contents check may not read the whole object.
+	if _, err := io.Copy(io.Discard, tr); err != nil {
+		return append(problems, fmt.Sprintf("failed to read object: %s", err))
+	}
+	checksum := fmt.Sprintf("%x", h.Sum(nil))
+	if a.Sha256 != "" {
+		if checksum != a.Sha256 {
+			problems = append(problems, fmt.Sprintf("object has checksum %s, expected %s", checksum, a.Sha256))
+		}
+	} else if !strings.HasPrefix(checksum, a.Checksum) || a.Checksum == "" {
+		// Artifacts created before full checksums were recorded only have the short form.
+		problems = append(problems, fmt.Sprintf("object has checksum %s, expected %s", checksum, a.Checksum))
+	}
+	return problems
+}
+
+const sqliteHeader = "SQLite format 3\x00"
+
+const parquetMagic = "PAR1"
+
+// verifyContents checks that the artifact can be opened and contains the expected members.
+// It returns a description of the problem, or the empty string if there is no problem.
+func verifyContents(r io.Reader, extension string, members []string) string {
+	if base, ok := strings.CutSuffix(extension, ".xz"); ok {
+		xr := xz.NewReader(r)
+		defer xr.Close()
+		r = xr
+		extension = base
+	}
+	switch extension {
+	case "tar":
+		found := map[string]bool{}
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Sprintf("failed to read archive: %s", err)
+			}
+			found[hdr.Name] = true
+		}
+		var missing []string
+		for _, member := range members {
+			if !found[member] {
+				missing = append(missing, member)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Sprintf("archive is missing %s", strings.Join(missing, ", "))
+		}
+	case "db":
+		b := make([]byte, len(sqliteHeader))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return fmt.Sprintf("failed to read database: %s", err)
+		}
+		if !bytes.Equal(b, []byte(sqliteHeader)) {
+			return "database is not a SQLite file"
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return fmt.Sprintf("failed to read database: %s", err)
+		}
+	case "parquet":
+		head := make([]byte, len(parquetMagic))
+		if _, err := io.ReadFull(r, head); err != nil {
+			return fmt.Sprintf("failed to read Parquet file: %s", err)
+		}
+		var tail tailWriter
+		if _, err := io.Copy(&tail, r); err != nil {
+			return fmt.Sprintf("failed to read Parquet file: %s", err)
+		}
+		if string(head) != parquetMagic || string(tail.b) != parquetMagic {
+			return "file is not a Parquet file"
+		}
+	}
+	return ""
+}
+
+// tailWriter keeps the last bytes of a Parquet file that are written to it.
+type tailWriter struct {
+	b []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	if n := len(w.b) - len(parquetMagic); n > 0 {
+		w.b = append(w.b[:0], w.b[n:]...)
+	}
+	return len(p), nil
+}
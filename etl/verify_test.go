@@ -0,0 +1,151 @@
+package etl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/jamespfennell/subwaydata.nyc/etl/export"
+	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	ec, sc := newTestClient(t)
+	day := metadata.NewDay(2022, time.January, 7)
+
+	var csv bytes.Buffer
+	if err := export.Export(&journal.Journal{}, fmt.Sprintf("%s%s_", ec.RemotePrefix, day), &csv); err != nil {
+		t.Fatalf("failed to create csv export: %s", err)
+	}
+	artifacts := map[string]metadata.Artifact{}
+	for _, object := range []struct {
+		name      string
+		extension string
+		content   []byte
+	}{
+		{metadata.ArtifactCsv, "tar.xz", csv.Bytes()},
+		// A valid archive that does not contain the GTFS-RT readme.
+		{metadata.ArtifactGtfsrt, "tar.xz", csv.Bytes()},
+		{"other", "bin", []byte("data")},
+		{"parquet_trips", "parquet", []byte("data")},
+	} {
+		path := artifactPath(ec, day, object.name, checksum(object.content), object.extension)
+		if err := sc.Write(ctx, bytes.NewReader(object.content), path); err != nil {
+			t.Fatalf("failed to write object: %s", err)
+		}
+		artifacts[object.name] = metadata.Artifact{
+			Size:     int64(len(object.content)),
+			Path:     path,
+			Checksum: checksum(object.content),
+			Sha256:   fmt.Sprintf("%x", sha256.Sum256(object.content)),
+		}
+	}
+	artifacts["other"] = metadata.Artifact{Size: 5, Path: artifacts["other"].Path, Checksum: "000000000000"}
+	// An artifact with only the short form of the checksum is checked against the short form.
+	artifacts["legacy"] = metadata.Artifact{Size: 4, Path: artifacts["other"].Path, Checksum: checksum([]byte("data"))}
+	artifacts["missing"] = metadata.Artifact{Path: "2022-01/missing.tar.xz"}
+	if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		m.ProcessedDays = []metadata.ProcessedDay{{Day: day, Artifacts: artifacts}}
+		return true
+	}); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+
+	report, err := Verify(ctx, VerifyOptions{}, ec, sc)
+	if err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	wantProblems := []string{
+		"missing: object does not exist",
+		"other: object has size 4, expected 5",
+	}
+	if got := problems(report); !reflect.DeepEqual(got, wantProblems) {
+		t.Errorf("problems actual %v != expected %v", got, wantProblems)
+	}
+
+	report, err = Verify(ctx, VerifyOptions{Download: true}, ec, sc)
+	if err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	wantProblems = []string{
+		"gtfsrt: archive is missing gtfsrt_readme.md",
+		"missing: object does not exist",
+		"other: object has size 4, expected 5",
+		fmt.Sprintf("other: object has checksum %x, expected 000000000000", sha256.Sum256([]byte("data"))),
+		"parquet_trips: file is not a Parquet file",
+	}
+	if got := problems(report); !reflect.DeepEqual(got, wantProblems) {
+		t.Errorf("problems actual %v != expected %v", got, wantProblems)
+	}
+	if report.NumDays != 1 || report.NumArtifacts != 6 {
+		t.Errorf("report counts actual (%d, %d) != expected (1, 6)", report.NumDays, report.NumArtifacts)
+	}
+}
+
+func TestVerify_StatError(t *testing.T) {
+	ctx := context.Background()
+	ec := newTestConfig(t)
+	backend, err := storage.NewBackend(ec)
+	if err != nil {
+		t.Fatalf("failed to create backend: %s", err)
+	}
+	sc := storage.NewClientWithBackend(ec, &failingStatBackend{Backend: backend, key: "2022-01/unavailable.tar.xz"})
+	day := metadata.NewDay(2022, time.January, 7)
+	if err := sc.Write(ctx, bytes.NewReader([]byte("data")), "2022-01/available.bin"); err != nil {
+		t.Fatalf("failed to write object: %s", err)
+	}
+	if err := sc.UpdateMetadata(ctx, func(m *metadata.Metadata) bool {
+		m.ProcessedDays = []metadata.ProcessedDay{{Day: day, Artifacts: map[string]metadata.Artifact{
+			"available":   {Size: 4, Path: "2022-01/available.bin"},
+			"unavailable": {Path: "2022-01/unavailable.tar.xz"},
+		}}}
+		return true
+	}); err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+
+	report, err := Verify(ctx, VerifyOptions{}, ec, sc)
+	if err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	wantProblems := []string{"unavailable: failed to stat object: service unavailable"}
+	if got := problems(report); !reflect.DeepEqual(got, wantProblems) {
+		t.Errorf("problems actual %v != expected %v", got, wantProblems)
+	}
+	if report.NumArtifacts != 2 {
+		t.Errorf("number of artifacts actual %d != expected 2", report.NumArtifacts)
+	}
+}
+
+// failingStatBackend fails to stat one key.
+type failingStatBackend struct {
+	storage.Backend
+	key string
+}
+
+func (b *failingStatBackend) Stat(ctx context.Context, key string) (storage.ObjectInfo, error) {
+	if key == b.key {
+		return storage.ObjectInfo{}, errors.New("service unavailable")
+	}
+	return b.Backend.Stat(ctx, key)
+}
+
+func checksum(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:12]
+}
+
+func problems(report *VerifyReport) []string {
+	var result []string
+	for _, problem := range report.Problems {
+		result = append(result, fmt.Sprintf("%s: %s", problem.Artifact, problem.Problem))
+	}
+	return result
+}
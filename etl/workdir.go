@@ -0,0 +1,181 @@
+package etl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/jamespfennell/subwaydata.nyc/etl/clock"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+type RunOptions struct {
+	// Directory in which to create the working directories of runs.
+	// If set, the working directory of a run is keyed by the day, feeds and software version, and is retained
+	// if the run fails so that a retry resumes from the stage that failed.
+	// If empty, each run uses a fresh temporary directory.
+	WorkDir string
+
+	// If true, the working directory is not deleted when the run finishes, even if it succeeded.
+	KeepWorkDir bool
+
+	// Clock to use. If nil, the system clock is used.
+	Clock clock.Clock
+
+	// If set, the stages of running days are recorded in it.
+	Progress *Progress
+}
+
+// workDir is the working directory of a single run of the pipeline.
+//
+// Stages that complete record a marker in the directory. When a run resumes in the same directory,
+// completed stages are skipped.
+type workDir struct {
+	path       string
+	persistent bool
+	keep       bool
+}
+
+func openWorkDir(day metadata.Day, feedIDs []string, opts RunOptions) (*workDir, error) {
+	w := &workDir{keep: opts.KeepWorkDir}
+	if opts.WorkDir == "" {
+		path, err := os.MkdirTemp("", fmt.Sprintf("subwaydatanyc_%s_*", day))
+		if err != nil {
+			return nil, err
+		}
+		w.path = path
+	} else {
+		w.path = filepath.Join(opts.WorkDir, workDirName(day, feedIDs))
+		w.persistent = true
+	}
+	for _, dir := range []string{w.rawDir(), w.artifactsDir(), w.stagesDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// workDirName returns the name of the persistent working directory for a run.
+// Runs with different feeds or software versions produce different data, so they use different directories.
+func workDirName(day metadata.Day, feedIDs []string) string {
+	sortedFeedIDs := append([]string(nil), feedIDs...)
+	sort.Strings(sortedFeedIDs)
+	feedsHash := sha256.Sum256([]byte(strings.Join(sortedFeedIDs, "\n")))
+	return fmt.Sprintf("subwaydatanyc_%s_v%d_%x", day, softwareVersion, feedsHash[:4])
+}
+
+func (w *workDir) rawDir() string {
+	return filepath.Join(w.path, "raw")
+}
+
+func (w *workDir) artifactsDir() string {
+	return filepath.Join(w.path, "artifacts")
+}
+
+func (w *workDir) stagesDir() string {
+	return filepath.Join(w.path, "stages")
+}
+
+func (w *workDir) journalPath() string {
+	return filepath.Join(w.path, "journal.json")
+}
+
+// writeJournal saves the journal in the working directory.
+// The journal can be large, so it is written a trip at a time rather than serialized in memory first.
+func (w *workDir) writeJournal(j *journal.Journal) error {
+	f, err := os.Create(w.journalPath())
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+	_, err = bw.WriteString(`{"Trips":[`)
+	for i := range j.Trips {
+		if err != nil {
+			break
+		}
+		if i > 0 {
+			err = bw.WriteByte(',')
+		}
+		if err == nil {
+			err = enc.Encode(&j.Trips[i])
+		}
+	}
+	if err == nil {
+		_, err = bw.WriteString("]}\n")
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readJournal reads the journal saved in the working directory, a trip at a time.
+func (w *workDir) readJournal() (*journal.Journal, error) {
+	f, err := os.Open(w.journalPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for _, want := range []json.Token{json.Delim('{'), "Trips", json.Delim('[')} {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if token != want {
+			return nil, fmt.Errorf("unexpected token %v in journal, expected %v", token, want)
+		}
+	}
+	j := &journal.Journal{}
+	for dec.More() {
+		var trip journal.Trip
+		if err := dec.Decode(&trip); err != nil {
+			return nil, err
+		}
+		j.Trips = append(j.Trips, trip)
+	}
+	return j, nil
+}
+
+// runStage runs the stage unless it has already completed in this working directory.
+// It returns true if the stage was skipped.
+func (w *workDir) runStage(stage string, f func() error) (bool, error) {
+	marker := filepath.Join(w.stagesDir(), stage+".done")
+	if _, err := os.Stat(marker); err == nil {
+		log.Printf("Skipping stage %s: already completed in %s", stage, w.path)
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	if err := f(); err != nil {
+		return false, err
+	}
+	return false, os.WriteFile(marker, nil, 0644)
+}
+
+// close deletes the working directory unless it should be kept.
+// Persistent working directories are kept if the run failed so that it can be resumed.
+func (w *workDir) close(runErr error) {
+	if w.keep || (w.persistent && runErr != nil) {
+		log.Printf("Keeping working directory %s", w.path)
+		return
+	}
+	if err := os.RemoveAll(w.path); err != nil {
+		log.Printf("Failed to delete working directory %s: %s", w.path, err)
+	}
+}
@@ -0,0 +1,120 @@
+package etl
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jamespfennell/gtfs/journal"
+	"github.com/jamespfennell/subwaydata.nyc/metadata"
+)
+
+func TestWorkDir_Resume(t *testing.T) {
+	day := metadata.NewDay(2022, time.January, 7)
+	opts := RunOptions{WorkDir: t.TempDir()}
+	var numRuns int
+	stage := func() error {
+		numRuns++
+		return nil
+	}
+
+	w, err := openWorkDir(day, []string{"feed1", "feed2"}, opts)
+	if err != nil {
+		t.Fatalf("openWorkDir failed: %s", err)
+	}
+	if skipped, err := w.runStage("stage", stage); err != nil || skipped {
+		t.Fatalf("runStage actual (%t, %v) != expected (false, nil)", skipped, err)
+	}
+	w.close(errors.New("later stage failed"))
+
+	// The feeds are in a different order but the working directory is the same.
+	w, err = openWorkDir(day, []string{"feed2", "feed1"}, opts)
+	if err != nil {
+		t.Fatalf("openWorkDir failed: %s", err)
+	}
+	if skipped, err := w.runStage("stage", stage); err != nil || !skipped {
+		t.Fatalf("runStage actual (%t, %v) != expected (true, nil)", skipped, err)
+	}
+	if numRuns != 1 {
+		t.Errorf("stage ran %d times, expected 1", numRuns)
+	}
+	w.close(nil)
+	if _, err := os.Stat(w.path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("working directory %s exists after a successful run", w.path)
+	}
+}
+
+func TestWorkDir_FailedStageIsRetried(t *testing.T) {
+	day := metadata.NewDay(2022, time.January, 7)
+	opts := RunOptions{WorkDir: t.TempDir()}
+	w, err := openWorkDir(day, []string{"feed1"}, opts)
+	if err != nil {
+		t.Fatalf("openWorkDir failed: %s", err)
+	}
+	if _, err := w.runStage("stage", func() error { return errors.New("failed") }); err == nil {
+		t.Fatalf("runStage succeeded, expected error")
+	}
+	if skipped, err := w.runStage("stage", func() error { return nil }); err != nil || skipped {
+		t.Errorf("runStage actual (%t, %v) != expected (false, nil)", skipped, err)
+	}
+}
+
+func TestWorkDir_Close(t *testing.T) {
+	day := metadata.NewDay(2022, time.January, 7)
+	for _, tc := range []struct {
+		name     string
+		opts     RunOptions
+		runErr   error
+		wantKept bool
+	}{
+		{"temporary, success", RunOptions{}, nil, false},
+		{"temporary, failure", RunOptions{}, errors.New("failed"), false},
+		{"temporary, keep", RunOptions{KeepWorkDir: true}, nil, true},
+		{"persistent, success", RunOptions{WorkDir: t.TempDir()}, nil, false},
+		{"persistent, failure", RunOptions{WorkDir: t.TempDir()}, errors.New("failed"), true},
+		{"persistent, keep", RunOptions{WorkDir: t.TempDir(), KeepWorkDir: true}, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := openWorkDir(day, []string{"feed1"}, tc.opts)
+			if err != nil {
+				t.Fatalf("openWorkDir failed: %s", err)
+			}
+			defer os.RemoveAll(w.path)
+			w.close(tc.runErr)
+			_, err = os.Stat(w.path)
+			if kept := err == nil; kept != tc.wantKept {
+				t.Errorf("working directory kept actual %t != expected %t", kept, tc.wantKept)
+			}
+		})
+	}
+}
+
+func TestWorkDir_Journal(t *testing.T) {
+	w, err := openWorkDir(metadata.NewDay(2022, time.January, 7), []string{"feedID"}, RunOptions{WorkDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to open working directory: %s", err)
+	}
+	departureTime := time.Unix(200, 0).UTC()
+	for _, j := range []*journal.Journal{
+		{},
+		{Trips: []journal.Trip{
+			{TripUID: "a", StartTime: time.Unix(100, 0).UTC(), StopTimes: []journal.StopTime{
+				{StopID: "stop", DepartureTime: &departureTime, LastObserved: time.Unix(300, 0).UTC()},
+			}},
+			{TripUID: "b", NumUpdates: 2},
+		}},
+	} {
+		if err := w.writeJournal(j); err != nil {
+			t.Fatalf("writeJournal failed: %s", err)
+		}
+		got, err := w.readJournal()
+		if err != nil {
+			t.Fatalf("readJournal failed: %s", err)
+		}
+		if !reflect.DeepEqual(got, j) {
+			t.Errorf("journal actual %+v != expected %+v", got, j)
+		}
+	}
+}
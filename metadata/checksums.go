@@ -0,0 +1,37 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Sha256Sums returns a SHA256SUMS file for the artifacts of the processed days in the provided month (YYYY-MM).
+// The file is in the format read by `sha256sum -c`, and lists each artifact under the name returned by fileName.
+// Artifacts without a full SHA-256 checksum are omitted.
+func (m *Metadata) Sha256Sums(month string, fileName func(day Day, name string, a Artifact) string) []byte {
+	type line struct {
+		fileName string
+		sha256   string
+	}
+	var lines []line
+	for _, p := range m.ProcessedDays {
+		if p.Day.MonthString() != month {
+			continue
+		}
+		for name, a := range p.Artifacts {
+			if a.Sha256 == "" {
+				continue
+			}
+			lines = append(lines, line{fileName: fileName(p.Day, name, a), sha256: a.Sha256})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].fileName < lines[j].fileName
+	})
+	var b bytes.Buffer
+	for _, l := range lines {
+		fmt.Fprintf(&b, "%s  %s\n", l.sha256, l.fileName)
+	}
+	return b.Bytes()
+}
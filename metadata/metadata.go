@@ -3,11 +3,53 @@ package metadata
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 	"time"
 )
 
 type Metadata struct {
 	ProcessedDays []ProcessedDay
+
+	// Days for which the most recent attempt to run the pipeline failed.
+	FailedDays []FailedDay `json:",omitempty"`
+}
+
+// FailedDay records the failed attempts to run the pipeline for a day.
+type FailedDay struct {
+	Day   Day
+	Feeds []string
+
+	// Stage of the pipeline that failed in the last attempt.
+	Stage string
+
+	// Error from the last attempt.
+	Error string
+
+	// Number of consecutive failed attempts.
+	Attempts    int
+	LastAttempt time.Time
+}
+
+// FailedDay returns the failure record for the provided day, or nil if there is none.
+func (m *Metadata) FailedDay(day Day) *FailedDay {
+	for i := range m.FailedDays {
+		if m.FailedDays[i].Day == day {
+			return &m.FailedDays[i]
+		}
+	}
+	return nil
+}
+
+// RemoveFailedDay removes the failure record for the provided day and returns whether there was one.
+func (m *Metadata) RemoveFailedDay(day Day) bool {
+	for i := range m.FailedDays {
+		if m.FailedDays[i].Day == day {
+			m.FailedDays = append(m.FailedDays[:i], m.FailedDays[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
 type Day struct {
@@ -21,14 +63,117 @@ type ProcessedDay struct {
 	Feeds           []string
 	Created         time.Time
 	SoftwareVersion int
-	Csv             Artifact
-	Gtfsrt          Artifact
+
+	// Artifacts produced for the day, keyed by the name of the artifact; e.g. "csv" or "gtfsrt".
+	Artifacts map[string]Artifact
+
+	Quality *QualitySummary `json:",omitempty"`
+
+	// Periods in which no GTFS-RT data was collected for each feed.
+	// Trips missing during these periods are likely missing because of the gap, rather than because of service changes.
+	Coverage map[string][]Gap `json:",omitempty"`
+}
+
+// Names of the artifacts that are produced for every processed day.
+const (
+	ArtifactCsv    = "csv"
+	ArtifactGtfsrt = "gtfsrt"
+)
+
+// UnmarshalJSON unmarshals a processed day, migrating artifacts stored in the legacy format.
+// Previously the csv and GTFS-RT artifacts had dedicated fields on the processed day.
+func (p *ProcessedDay) UnmarshalJSON(data []byte) error {
+	type processedDay ProcessedDay
+	var legacy struct {
+		processedDay
+		Csv    *Artifact
+		Gtfsrt *Artifact
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	*p = ProcessedDay(legacy.processedDay)
+	for name, a := range map[string]*Artifact{
+		ArtifactCsv:    legacy.Csv,
+		ArtifactGtfsrt: legacy.Gtfsrt,
+	} {
+		if a == nil {
+			continue
+		}
+		if p.Artifacts == nil {
+			p.Artifacts = map[string]Artifact{}
+		}
+		if _, ok := p.Artifacts[name]; !ok {
+			p.Artifacts[name] = *a
+		}
+	}
+	return nil
+}
+
+// MarshalJSON marshals a processed day.
+// The csv and GTFS-RT artifacts are also written to their legacy fields, so that consumers of the
+// metadata that predate the artifacts map keep working.
+func (p ProcessedDay) MarshalJSON() ([]byte, error) {
+	type processedDay ProcessedDay
+	legacy := struct {
+		processedDay
+		Csv    *Artifact `json:",omitempty"`
+		Gtfsrt *Artifact `json:",omitempty"`
+	}{processedDay: processedDay(p)}
+	if a, ok := p.Artifacts[ArtifactCsv]; ok {
+		legacy.Csv = &a
+	}
+	if a, ok := p.Artifacts[ArtifactGtfsrt]; ok {
+		legacy.Gtfsrt = &a
+	}
+	return json.Marshal(legacy)
 }
 
 type Artifact struct {
-	Size     int64
-	Path     string
+	Size int64
+	Path string
+
+	// First 12 hex characters of the SHA-256 checksum, which are included in the path.
 	Checksum string
+
+	// Full hex encoded SHA-256 checksum.
+	// It is empty for artifacts created before full checksums were recorded.
+	Sha256 string `json:",omitempty"`
+}
+
+// Extension returns the file extension of the artifact's path, without a leading period; e.g. "tar.xz".
+func (a Artifact) Extension() string {
+	base := path.Base(a.Path)
+	i := strings.Index(base, ".")
+	if i < 0 {
+		return ""
+	}
+	return base[i+1:]
+}
+
+// Gap is an interval of time in which no GTFS-RT data was collected for a feed.
+type Gap struct {
+	Start time.Time
+	End   time.Time
+}
+
+// QualitySummary summarizes the data quality report for a processed day.
+// The full report is included in the CSV archive.
+type QualitySummary struct {
+	NumTrips     int
+	NumStopTimes int
+
+	// Number of trips that have no stop times.
+	TripsWithNoStopTimes int
+
+	// Number of stop times that have neither an arrival nor a departure time.
+	StopTimesWithNoTimes int
+
+	// Fraction of trips that were never marked as past.
+	FractionTripsNotMarkedPast float64
+
+	// Total number of minutes, summed over all feeds, in which no GTFS-RT data was collected.
+	CoverageGapMinutes float64
 }
 
 func NewDay(year int, month time.Month, day int) Day {
@@ -51,6 +196,30 @@ func ParseDay(s string) (Day, error) {
 	}, nil
 }
 
+// ParseDays parses a single day (YYYY-MM-DD) or an inclusive range of days (YYYY-MM-DD..YYYY-MM-DD).
+func ParseDays(s string) ([]Day, error) {
+	rawStart, rawEnd, isRange := strings.Cut(s, "..")
+	start, err := ParseDay(rawStart)
+	if err != nil {
+		return nil, err
+	}
+	if !isRange {
+		return []Day{start}, nil
+	}
+	end, err := ParseDay(rawEnd)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("range %q ends before it starts", s)
+	}
+	var days []Day
+	for d := start; !end.Before(d); d = d.Next() {
+		days = append(days, d)
+	}
+	return days, nil
+}
+
 func (d *Day) UnmarshalJSON(data []byte) error {
 	var s string
 	if err := json.Unmarshal(data, &s); err != nil {
@@ -3,12 +3,20 @@ package metadata
 import (
 	_ "embed"
 	"encoding/json"
+	"path"
+	"reflect"
 	"testing"
+	"time"
 )
 
-//go:embed nycsubway.json
+//go:embed nycsubway_artifacts.json
 var sampleConfig string
 
+// Metadata written before artifacts were stored in a map.
+//
+//go:embed nycsubway.json
+var legacyConfig string
+
 func TestSerializeRoundTrip(t *testing.T) {
 	var c Metadata
 	if err := json.Unmarshal([]byte(sampleConfig), &c); err != nil {
@@ -22,3 +30,141 @@ func TestSerializeRoundTrip(t *testing.T) {
 		t.Errorf("re-written metadata doesn't match original. Original\n%s\nRe-Written:\n%s", sampleConfig, string(b))
 	}
 }
+
+// Consumers of the metadata that predate the artifacts map must be able to read metadata written now.
+func TestLegacyFieldsRoundTrip(t *testing.T) {
+	var c Metadata
+	if err := json.Unmarshal([]byte(legacyConfig), &c); err != nil {
+		t.Fatalf("failed to parse metadata: %s", err)
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to write metadata: %s", err)
+	}
+	type legacyArtifact struct {
+		Size     int64
+		Path     string
+		Checksum string
+	}
+	type legacyMetadata struct {
+		ProcessedDays []struct {
+			Day             string
+			Feeds           []string
+			Created         time.Time
+			SoftwareVersion int
+			Csv             *legacyArtifact
+			Gtfsrt          *legacyArtifact
+		}
+	}
+	var original, rewritten legacyMetadata
+	if err := json.Unmarshal([]byte(legacyConfig), &original); err != nil {
+		t.Fatalf("failed to parse legacy metadata: %s", err)
+	}
+	if err := json.Unmarshal(b, &rewritten); err != nil {
+		t.Fatalf("failed to parse re-written metadata: %s", err)
+	}
+	if !reflect.DeepEqual(rewritten, original) {
+		t.Errorf("re-written legacy fields actual %+v != expected %+v", rewritten, original)
+	}
+}
+
+const legacyProcessedDay = `{
+  "Day": "2022-01-07",
+  "Csv": {
+    "Size": 55912,
+    "Path": "2022-01/subwaydata-nyc_2022-01-07_csv_cbe30b6f6c38.tar.xz",
+    "Checksum": "cbe30b6f6c38"
+  },
+  "Gtfsrt": {
+    "Size": 2555552,
+    "Path": "2022-01/subwaydata-nyc_2022-01-07_gtfsrt_84469a0ff9e5.tar.xz",
+    "Checksum": "84469a0ff9e5"
+  }
+}`
+
+func TestUnmarshalLegacyArtifacts(t *testing.T) {
+	var p ProcessedDay
+	if err := json.Unmarshal([]byte(legacyProcessedDay), &p); err != nil {
+		t.Fatalf("failed to parse processed day: %s", err)
+	}
+	expected := map[string]Artifact{
+		ArtifactCsv: {
+			Size:     55912,
+			Path:     "2022-01/subwaydata-nyc_2022-01-07_csv_cbe30b6f6c38.tar.xz",
+			Checksum: "cbe30b6f6c38",
+		},
+		ArtifactGtfsrt: {
+			Size:     2555552,
+			Path:     "2022-01/subwaydata-nyc_2022-01-07_gtfsrt_84469a0ff9e5.tar.xz",
+			Checksum: "84469a0ff9e5",
+		},
+	}
+	if !reflect.DeepEqual(p.Artifacts, expected) {
+		t.Errorf("artifacts actual %+v != expected %+v", p.Artifacts, expected)
+	}
+	if p.Day != NewDay(2022, time.January, 7) {
+		t.Errorf("day actual %s != expected 2022-01-07", p.Day)
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	jan30 := NewDay(2022, time.January, 30)
+	jan31 := NewDay(2022, time.January, 31)
+	feb1 := NewDay(2022, time.February, 1)
+	testCases := []struct {
+		in      string
+		wantOut []Day
+		wantErr bool
+	}{
+		{in: "2022-01-30", wantOut: []Day{jan30}},
+		{in: "2022-01-30..2022-02-01", wantOut: []Day{jan30, jan31, feb1}},
+		{in: "2022-01-31..2022-01-31", wantOut: []Day{jan31}},
+		{in: "2022-02-01..2022-01-30", wantErr: true},
+		{in: "2022-01-30..", wantErr: true},
+		{in: "January 30", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := ParseDays(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ParseDays(%q) succeeded, expected error", tc.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDays(%q) failed: %s", tc.in, err)
+			}
+			if !reflect.DeepEqual(out, tc.wantOut) {
+				t.Errorf("ParseDays(%q) actual %v != expected %v", tc.in, out, tc.wantOut)
+			}
+		})
+	}
+}
+
+func TestSha256Sums(t *testing.T) {
+	m := Metadata{ProcessedDays: []ProcessedDay{
+		{
+			Day: NewDay(2022, time.February, 1),
+			Artifacts: map[string]Artifact{
+				"csv": {Path: "2022-02/a_2022-02-01_csv_cccc.tar.xz", Sha256: "cccc0000"},
+			},
+		},
+		{
+			Day: NewDay(2022, time.January, 31),
+			Artifacts: map[string]Artifact{
+				"gtfsrt": {Path: "2022-01/a_2022-01-31_gtfsrt_bbbb.tar.xz", Sha256: "bbbb0000"},
+				"csv":    {Path: "2022-01/a_2022-01-31_csv_aaaa.tar.xz", Sha256: "aaaa0000"},
+				"legacy": {Path: "2022-01/a_2022-01-31_legacy_dddd.tar.xz"},
+			},
+		},
+	}}
+	got := string(m.Sha256Sums("2022-01", func(day Day, name string, a Artifact) string {
+		return path.Base(a.Path)
+	}))
+	want := "aaaa0000  a_2022-01-31_csv_aaaa.tar.xz\n" +
+		"bbbb0000  a_2022-01-31_gtfsrt_bbbb.tar.xz\n"
+	if got != want {
+		t.Errorf("Sha256Sums actual:\n%s\n!= expected:\n%s", got, want)
+	}
+}
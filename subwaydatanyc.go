@@ -4,11 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"time"
 
 	hconfig "github.com/jamespfennell/hoard/config"
 	"github.com/jamespfennell/subwaydata.nyc/etl"
 	"github.com/jamespfennell/subwaydata.nyc/etl/config"
+	"github.com/jamespfennell/subwaydata.nyc/etl/monitoring"
 	"github.com/jamespfennell/subwaydata.nyc/etl/periodic"
 	"github.com/jamespfennell/subwaydata.nyc/etl/storage"
 	"github.com/jamespfennell/subwaydata.nyc/metadata"
@@ -17,8 +22,10 @@ import (
 )
 
 const (
-	etlConfig   = "etl-config"
-	hoardConfig = "hoard-config"
+	etlConfig      = "etl-config"
+	hoardConfig    = "hoard-config"
+	pushgatewayUrl = "pushgateway-url"
+	metricsAddr    = "metrics-addr"
 )
 
 func main() {
@@ -41,15 +48,45 @@ func main() {
 						Usage:    "path to the ETL config file",
 						Required: true,
 					},
+					&cli.StringFlag{
+						Name:  pushgatewayUrl,
+						Usage: "URL of a Prometheus Pushgateway to push the pipeline metrics to when the command finishes",
+					},
+					&cli.StringFlag{
+						Name: metricsAddr,
+						Usage: "address to serve the pipeline metrics on at /metrics while the command runs, e.g. localhost:9090; " +
+							"independent of the control server of the periodic command",
+					},
+				},
+				Before: func(c *cli.Context) error {
+					addr := c.String(metricsAddr)
+					if addr == "" {
+						return nil
+					}
+					mux := http.NewServeMux()
+					mux.Handle("/metrics", monitoring.Handler())
+					return serveHTTP("metrics", addr, mux)
+				},
+				After: func(c *cli.Context) error {
+					url := c.String(pushgatewayUrl)
+					if url == "" {
+						return nil
+					}
+					return monitoring.Push(url, "subwaydatanyc_etl")
 				},
 				Subcommands: []*cli.Command{
 					{
-						Name:  "delete",
-						Usage: "delete a range of processed days",
+						Name:      "delete",
+						Usage:     "delete a range of processed days",
+						UsageText: "etl delete [--day YYYY-MM-DD[..YYYY-MM-DD]]... [--objects] [--yes]",
 						Flags: []cli.Flag{
 							&cli.StringSliceFlag{
 								Name:  "day",
-								Usage: "day to delete",
+								Usage: "day (YYYY-MM-DD) or inclusive range of days (YYYY-MM-DD..YYYY-MM-DD) to delete",
+							},
+							&cli.BoolFlag{
+								Name:  "objects",
+								Usage: "also delete the artifacts of the days from object storage",
 							},
 							&cli.BoolFlag{
 								Name:  "yes",
@@ -62,48 +99,259 @@ func main() {
 								return err
 							}
 							var days []metadata.Day
-							for _, rawDay := range c.StringSlice("day") {
-								day, err := metadata.ParseDay(rawDay)
+							for _, rawDays := range c.StringSlice("day") {
+								d, err := metadata.ParseDays(rawDays)
 								if err != nil {
-									return fmt.Errorf("failed to parse start date: %w", err)
+									return fmt.Errorf("failed to parse day: %w", err)
 								}
-								days = append(days, day)
+								days = append(days, d...)
+							}
+							opts := etl.DeleteOptions{
+								DryRun:        !c.Bool("yes"),
+								DeleteObjects: c.Bool("objects"),
 							}
 							ctx := context.Background()
-							return etl.DeleteDays(ctx, days, !c.Bool("yes"), session.ec, session.sc)
+							return etl.DeleteDays(ctx, days, opts, session.ec, session.sc)
 						},
 					},
 					{
-						Name:        "run",
-						Usage:       "run the ETL pipeline for a specific day",
-						UsageText:   "etl run YYYY-MM-DD",
-						Description: "Runs the pipeline for the specified day (YYYY-MM-DD).",
+						Name:      "run",
+						Usage:     "run the ETL pipeline for specific days",
+						UsageText: "etl run [--feed FEED_ID]... [--concurrency N] YYYY-MM-DD[..YYYY-MM-DD]...",
+						Description: "Runs the pipeline for the specified days (YYYY-MM-DD) or inclusive ranges of days " +
+							"(YYYY-MM-DD..YYYY-MM-DD). By default the feeds active on each day according to the ETL config are used.",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:        "feed",
+								Aliases:     []string{"f"},
+								Usage:       "ID of a feed to process, instead of the feeds in the ETL config",
+								DefaultText: "feeds in the ETL config",
+							},
+							&cli.IntFlag{
+								Name:    "concurrency",
+								Aliases: []string{"c"},
+								Value:   1,
+								Usage:   "number of days to run concurrently",
+							},
+							&cli.StringFlag{
+								Name:  "workdir",
+								Usage: "directory for persistent working directories, so that failed runs resume from the failed stage",
+							},
+							&cli.BoolFlag{
+								Name:  "keep-workdir",
+								Usage: "don't delete working directories after runs finish",
+							},
+						},
 						Action: func(c *cli.Context) error {
 							session, err := newSession(c)
 							if err != nil {
 								return err
 							}
-							args := c.Args()
-							switch args.Len() {
-							case 0:
+							args := c.Args().Slice()
+							if len(args) == 0 {
 								return fmt.Errorf("no day provided")
-							case 1:
-								d, err := metadata.ParseDay(args.Get(0))
+							}
+							var days []metadata.Day
+							for _, arg := range args {
+								d, err := metadata.ParseDays(arg)
 								if err != nil {
 									return err
 								}
-								return etl.Run(
-									context.Background(),
-									d,
-									// TODO: !!!
-									[]string{"nycsubway_L"},
-									session.ec,
-									session.hc,
-									session.sc,
-								)
-							default:
-								return fmt.Errorf("too many command line arguments passed")
+								days = append(days, d...)
+							}
+							opts := etl.RunDaysOptions{
+								RunOptions:  runOptions(c),
+								FeedIDs:     c.StringSlice("feed"),
+								Concurrency: c.Int("concurrency"),
 							}
+							return etl.RunDays(context.Background(), days, session.ec, session.hc, session.sc, opts)
+						},
+					},
+					{
+						Name:  "gc",
+						Usage: "delete artifacts in object storage that are not referenced by the metadata",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "grace-period",
+								Value: 24 * time.Hour,
+								Usage: "retain unreferenced artifacts modified more recently than this",
+							},
+							&cli.BoolFlag{
+								Name:  "yes",
+								Usage: "perform the deletions",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							session, err := newSession(c)
+							if err != nil {
+								return err
+							}
+							opts := etl.GarbageCollectOptions{
+								DryRun:      !c.Bool("yes"),
+								GracePeriod: c.Duration("grace-period"),
+							}
+							return etl.GarbageCollect(context.Background(), opts, session.ec, session.sc)
+						},
+					},
+					{
+						Name:  "verify",
+						Usage: "verify that the artifacts referenced by the metadata are intact",
+						Description: "Checks that every artifact referenced by the metadata exists in object storage with the " +
+							"expected size and, if --download is set, the expected checksum and archive members. " +
+							"A JSON report is printed and the command fails if any problems are found.",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:        "day",
+								Usage:       "day (YYYY-MM-DD) or inclusive range of days (YYYY-MM-DD..YYYY-MM-DD) to verify",
+								DefaultText: "all days",
+							},
+							&cli.BoolFlag{
+								Name:  "download",
+								Usage: "download the artifacts to verify their checksums and contents",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							session, err := newSession(c)
+							if err != nil {
+								return err
+							}
+							opts := etl.VerifyOptions{
+								Download: c.Bool("download"),
+							}
+							for _, rawDays := range c.StringSlice("day") {
+								d, err := metadata.ParseDays(rawDays)
+								if err != nil {
+									return fmt.Errorf("failed to parse day: %w", err)
+								}
+								opts.Days = append(opts.Days, d...)
+							}
+							report, err := etl.Verify(context.Background(), opts, session.ec, session.sc)
+							if err != nil {
+								return err
+							}
+							b, err := json.MarshalIndent(report, "", "  ")
+							if err != nil {
+								return err
+							}
+							fmt.Println(string(b))
+							if len(report.Problems) > 0 {
+								return fmt.Errorf("found %d problem(s) in %d artifact(s)", len(report.Problems), report.NumArtifacts)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "metadata",
+						Usage: "manage the metadata",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "rebuild",
+								Usage: "rebuild the metadata from the artifacts in object storage",
+								Description: "Reconstructs the metadata from the artifacts in object storage, using the most recent " +
+									"artifact of each kind for each day, and prints the differences from the existing metadata.",
+								Flags: []cli.Flag{
+									&cli.IntFlag{
+										Name:        "software-version",
+										Usage:       "software version to record for rebuilt days",
+										DefaultText: "current version",
+									},
+									&cli.BoolFlag{
+										Name:  "yes",
+										Usage: "write the rebuilt metadata",
+									},
+								},
+								Action: func(c *cli.Context) error {
+									session, err := newSession(c)
+									if err != nil {
+										return err
+									}
+									opts := etl.RebuildMetadataOptions{
+										DryRun:          !c.Bool("yes"),
+										SoftwareVersion: c.Int("software-version"),
+									}
+									return etl.RebuildMetadata(context.Background(), opts, session.ec, session.sc)
+								},
+							},
+							{
+								Name:  "history",
+								Usage: "list the snapshots of the metadata",
+								Action: func(c *cli.Context) error {
+									session, err := newSession(c)
+									if err != nil {
+										return err
+									}
+									return etl.PrintMetadataHistory(context.Background(), session.sc)
+								},
+							},
+							{
+								Name:        "rollback",
+								Usage:       "replace the metadata with a snapshot",
+								UsageText:   "etl metadata rollback [--yes] TIMESTAMP",
+								Description: "Replaces the metadata with the snapshot that has the provided timestamp, as listed by the history command.",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "yes",
+										Usage: "perform the rollback",
+									},
+								},
+								Action: func(c *cli.Context) error {
+									session, err := newSession(c)
+									if err != nil {
+										return err
+									}
+									if c.Args().Len() != 1 {
+										return fmt.Errorf("expected exactly one snapshot timestamp")
+									}
+									return etl.RollbackMetadata(context.Background(), c.Args().Get(0), !c.Bool("yes"), session.sc)
+								},
+							},
+						},
+					},
+					{
+						Name:  "failures",
+						Usage: "manage the records of days that failed in the backlog",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "list",
+								Usage: "list the days that failed and when they will be retried",
+								Action: func(c *cli.Context) error {
+									session, err := newSession(c)
+									if err != nil {
+										return err
+									}
+									return etl.PrintFailedDays(context.Background(), session.ec, session.sc)
+								},
+							},
+							{
+								Name:        "clear",
+								Usage:       "clear failure records so that the backlog retries the days immediately",
+								UsageText:   "etl failures clear [--all] [DAY...]",
+								Description: "Days are in the form YYYY-MM-DD or a range YYYY-MM-DD..YYYY-MM-DD. Clearing the failure record of a quarantined day releases it from quarantine.",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "all",
+										Usage: "clear the failure records of all days",
+									},
+								},
+								Action: func(c *cli.Context) error {
+									session, err := newSession(c)
+									if err != nil {
+										return err
+									}
+									var days []metadata.Day
+									for _, arg := range c.Args().Slice() {
+										d, err := metadata.ParseDays(arg)
+										if err != nil {
+											return err
+										}
+										days = append(days, d...)
+									}
+									if len(days) == 0 && !c.Bool("all") {
+										return fmt.Errorf("no day provided")
+									}
+									return etl.ClearFailedDays(context.Background(), days, c.Bool("all"), session.sc)
+								},
+							},
 						},
 					},
 					{
@@ -128,6 +376,14 @@ func main() {
 								Aliases: []string{"d"},
 								Usage:   "only calculate the days that need to be updated, but don't update them",
 							},
+							&cli.StringFlag{
+								Name:  "workdir",
+								Usage: "directory for persistent working directories, so that failed runs resume from the failed stage",
+							},
+							&cli.BoolFlag{
+								Name:  "keep-workdir",
+								Usage: "don't delete working directories after runs finish",
+							},
 						},
 						Action: func(c *cli.Context) error {
 							session, err := newSession(c)
@@ -135,6 +391,7 @@ func main() {
 								return err
 							}
 							opts := etl.BacklogOptions{
+								RunOptions:  runOptions(c),
 								DryRun:      c.Bool("dry-run"),
 								Concurrency: c.Int("concurrency"),
 							}
@@ -142,12 +399,36 @@ func main() {
 								l := c.Int("limit")
 								opts.Limit = &l
 							}
-							return etl.Backlog(context.Background(), session.ec, session.hc, session.sc, opts)
+							_, err = etl.Backlog(context.Background(), session.ec, session.hc, session.sc, opts)
+							return err
 						},
 					},
 					{
-						Name:  "periodic",
-						Usage: "run the ETL pipeline periodically",
+						Name:      "periodic",
+						Usage:     "run the ETL pipeline periodically",
+						UsageText: "etl periodic SCHEDULE [SCHEDULE...]",
+						Description: "Runs the backlog according to the schedules. Each schedule is either a daily interval of the form " +
+							"HH:MM:SS-HH:MM:SS, or a cron expression optionally followed by a maximum duration, e.g. " +
+							"\"0 2 * * MON-FRI for 4h\" or \"*/30 * * * *\". Schedules are evaluated in the timezone of the ETL config " +
+							"unless a cron expression is prefixed by CRON_TZ=<timezone>. The backlog is stopped when its window ends; " +
+							"runs missed while the process was down or busy are caught up if their window is still open.\n\n" +
+							"If --control-addr or --control-port is set, an HTTP server on that address serves GET /status, which " +
+							"reports the next scheduled window, the days that are running and the result of the last run, " +
+							"POST /trigger, which runs the backlog immediately until the end of the current or next scheduled " +
+							"window, POST /pause and POST /resume, which pause and resume scheduled runs, and GET /metrics, which " +
+							"serves the Prometheus metrics of the pipeline. A window that opens while scheduled runs are paused is " +
+							"run when they are resumed, if it is still open.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name: "control-addr",
+								Usage: "address to run the control HTTP server on, e.g. localhost:8081, or :8081 to listen on all " +
+									"interfaces; if neither this nor --control-port is set, the server is not run",
+							},
+							&cli.IntFlag{
+								Name:  "control-port",
+								Usage: "port to run the control HTTP server on, listening on localhost only",
+							},
+						},
 						Action: func(c *cli.Context) error {
 							session, err := newSession(c)
 							if err != nil {
@@ -155,18 +436,28 @@ func main() {
 							}
 							args := c.Args().Slice()
 							if len(args) == 0 {
-								return fmt.Errorf("no intervals provided")
+								return fmt.Errorf("no schedules provided")
 							}
-							var intervals []periodic.Interval
+							var schedules []periodic.Schedule
 							for _, arg := range args {
-								interval, err := periodic.NewInterval(arg)
+								schedule, err := periodic.ParseSchedule(arg, session.ec.Timezone.AsLoc())
 								if err != nil {
-									return fmt.Errorf("failed to parse interval: %w", err)
+									return fmt.Errorf("failed to parse schedule: %w", err)
+								}
+								schedules = append(schedules, schedule)
+							}
+							controller := periodic.NewController()
+							addr := c.String("control-addr")
+							if addr == "" && c.IsSet("control-port") {
+								addr = fmt.Sprintf("localhost:%d", c.Int("control-port"))
+							}
+							if addr != "" {
+								if err := serveHTTP("control", addr, controller.Handler()); err != nil {
+									return err
 								}
-								intervals = append(intervals, interval)
 							}
 							ctx := context.Background()
-							periodic.Run(ctx, session.ec, session.hc, session.sc, intervals)
+							periodic.Run(ctx, session.ec, session.hc, session.sc, schedules, controller)
 							return nil
 						},
 					},
@@ -201,6 +492,22 @@ func main() {
 	}
 }
 
+// serveHTTP runs an HTTP server for the handler in the background.
+// The address is bound before it returns, so that an address that can't be used is reported as an error.
+func serveHTTP(name, addr string, handler http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start %s HTTP server: %w", name, err)
+	}
+	log.Printf("Launching %s HTTP server on %s\n", name, l.Addr())
+	go func() {
+		if err := http.Serve(l, handler); err != nil {
+			log.Printf("The %s HTTP server stopped: %s", name, err)
+		}
+	}()
+	return nil
+}
+
 type session struct {
 	ec *config.Config
 	hc *hconfig.Config
@@ -238,3 +545,10 @@ func newSession(c *cli.Context) (*session, error) {
 		sc: sc,
 	}, nil
 }
+
+func runOptions(c *cli.Context) etl.RunOptions {
+	return etl.RunOptions{
+		WorkDir:     c.String("workdir"),
+		KeepWorkDir: c.Bool("keep-workdir"),
+	}
+}
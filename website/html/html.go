@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,8 +13,6 @@ import (
 	"github.com/jamespfennell/subwaydata.nyc/website/static"
 )
 
-const dataBaseUrl = "https://data.subwaydata.nyc"
-
 var americaNewYork *time.Location
 
 func init() {
@@ -75,16 +74,20 @@ type year struct {
 type month struct {
 	Title string
 	ID    string
-	Days  []dayData
+	// Names of all artifacts that exist for some day in the month.
+	ArtifactNames []string
+	Days          []dayData
 }
 
 type dayData struct {
-	Title      string
-	CsvUrl     string
-	CsvSize    string
-	GtfsrtUrl  string
-	GtfsrtSize string
-	Updated    string
+	Title     string
+	Artifacts map[string]*artifactData
+	Updated   string
+}
+
+type artifactData struct {
+	Url  string
+	Size string
 }
 
 func ExploreTheData(m *metadata.Metadata) string {
@@ -108,14 +111,26 @@ func ExploreTheData(m *metadata.Metadata) string {
 			})
 			j += 1
 		}
-		year.Months[j].Days = append(year.Months[j].Days, dayData{
-			Title:      p.Day.Format("January 02, 2006"),
-			CsvUrl:     fmt.Sprintf("/data/subwaydatanyc_%s_csv.tar.xz", p.Day),
-			CsvSize:    formatBytes(p.Csv.Size),
-			GtfsrtUrl:  fmt.Sprintf("%s/%s", dataBaseUrl, p.Gtfsrt.Path),
-			GtfsrtSize: formatBytes(p.Gtfsrt.Size),
-			Updated:    p.Created.Format("January 02, 2006"),
-		})
+		d := dayData{
+			Title:     p.Day.Format("January 02, 2006"),
+			Artifacts: map[string]*artifactData{},
+			Updated:   p.Created.Format("January 02, 2006"),
+		}
+		for name, a := range p.Artifacts {
+			d.Artifacts[name] = &artifactData{
+				Url:  "/data/" + DataRedirectName(p.Day, name, a),
+				Size: formatBytes(a.Size),
+			}
+			if !slices.Contains(year.Months[j].ArtifactNames, name) {
+				year.Months[j].ArtifactNames = append(year.Months[j].ArtifactNames, name)
+			}
+		}
+		year.Months[j].Days = append(year.Months[j].Days, d)
+	}
+	for _, year := range years {
+		for j := range year.Months {
+			slices.Sort(year.Months[j].ArtifactNames)
+		}
 	}
 	input := struct {
 		Years       []*year
@@ -131,6 +146,17 @@ func ExploreTheData(m *metadata.Metadata) string {
 	return s.String()
 }
 
+// DataRedirectName returns the name under /data/ that redirects to the artifact.
+func DataRedirectName(day metadata.Day, name string, a metadata.Artifact) string {
+	return fmt.Sprintf("subwaydatanyc_%s_%s.%s", day, name, a.Extension())
+}
+
+// Sha256SumsName returns the name under /data/ of the SHA256SUMS file for a month (YYYY-MM).
+// The file lists the artifacts of the month by their DataRedirectName.
+func Sha256SumsName(month string) string {
+	return fmt.Sprintf("subwaydatanyc_%s_SHA256SUMS", month)
+}
+
 func ProgrammaticAccess() string {
 	return executeStaticTemplate(t.ProgrammaticAccess)
 }
@@ -17,6 +17,10 @@ func TestTemplates(t *testing.T) {
 			{
 				Day:     metadata.NewDay(2022, time.January, 27),
 				Created: time.Date(2022, time.January, 29, 5, 31, 0, 0, time.UTC),
+				Artifacts: map[string]metadata.Artifact{
+					metadata.ArtifactCsv:    {Size: 1000, Path: "2022-01/nycsubway_2022-01-27_csv_abc.tar.xz"},
+					metadata.ArtifactGtfsrt: {Size: 2000, Path: "2022-01/nycsubway_2022-01-27_gtfsrt_def.tar.xz"},
+				},
 			},
 		},
 	}
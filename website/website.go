@@ -21,6 +21,7 @@ const (
 	contentTypeCss  = "text/css"
 	contentTypeJpg  = "image/jpeg"
 	contentTypeJson = "application/json"
+	contentTypeText = "text/plain"
 )
 
 func Run(metadataUrl string, port int) {
@@ -57,6 +58,10 @@ func Run(metadataUrl string, port int) {
 	})
 	http.HandleFunc("/data/", func(rw http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path[6:]
+		if sha256Sums, ok := d.getSha256Sums(path); ok {
+			writeResponse(rw, sha256Sums, contentTypeText)
+			return
+		}
 		path, ok := d.getDataRedirect(path)
 		if !ok {
 			rw.WriteHeader(http.StatusNotFound)
@@ -95,6 +100,7 @@ type dynamicContent struct {
 	exploreTheData string
 	metadataJson   string
 	dataRedirects  map[string]string
+	sha256Sums     map[string]string
 }
 
 func newDynamicContent(metadataUrl string) *dynamicContent {
@@ -104,6 +110,7 @@ func newDynamicContent(metadataUrl string) *dynamicContent {
 		exploreTheData: html.ExploreTheData(nil),
 		metadataJson:   "\"failed to load metadata\"",
 		dataRedirects:  map[string]string{},
+		sha256Sums:     map[string]string{},
 	}
 	t := time.NewTicker(500 * time.Millisecond)
 	defer t.Stop()
@@ -150,8 +157,15 @@ func (d *dynamicContent) update() error {
 	home := html.Home(&m, &now)
 	exploreTheData := html.ExploreTheData(&m)
 	redirects := map[string]string{}
-	for i := range m.ProcessedDays {
-		redirects[fmt.Sprintf("subwaydatanyc_%s_csv.tar.xz", m.ProcessedDays[i].Day)] = m.ProcessedDays[i].Csv.Path
+	sha256Sums := map[string]string{}
+	for _, p := range m.ProcessedDays {
+		for name, a := range p.Artifacts {
+			redirects[html.DataRedirectName(p.Day, name, a)] = a.Path
+		}
+		month := p.Day.MonthString()
+		if _, ok := sha256Sums[html.Sha256SumsName(month)]; !ok {
+			sha256Sums[html.Sha256SumsName(month)] = string(m.Sha256Sums(month, html.DataRedirectName))
+		}
 	}
 	d.updateMutex.Lock()
 	defer d.updateMutex.Unlock()
@@ -159,6 +173,7 @@ func (d *dynamicContent) update() error {
 	d.exploreTheData = exploreTheData
 	d.metadataJson = string(b)
 	d.dataRedirects = redirects
+	d.sha256Sums = sha256Sums
 	return nil
 }
 
@@ -187,6 +202,13 @@ func (d *dynamicContent) getDataRedirect(url string) (string, bool) {
 	return s, b
 }
 
+func (d *dynamicContent) getSha256Sums(name string) (string, bool) {
+	d.updateMutex.RLock()
+	defer d.updateMutex.RUnlock()
+	s, b := d.sha256Sums[name]
+	return s, b
+}
+
 func writeResponse(w http.ResponseWriter, s string, contentType string) {
 	w.Header().Set("Content-Type", contentType)
 	if _, err := io.Copy(w, strings.NewReader(s)); err != nil {